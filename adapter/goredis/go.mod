@@ -0,0 +1,19 @@
+module github.com/plsmphnx/go-redis-bucket/adapter/goredis
+
+go 1.21
+
+require (
+	github.com/plsmphnx/go-redis-bucket v0.0.0
+	github.com/redis/go-redis/v9 v9.5.1
+	github.com/stretchr/testify v1.8.4
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/plsmphnx/go-redis-bucket => ../..
@@ -0,0 +1,182 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package goredis adapts go-redis v9 clients for use with the rate limiter.
+package goredis
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+// Client adapts any go-redis client, including cluster and ring clients, to
+// the interfaces used by the limiter.
+type Client struct{ redis.UniversalClient }
+
+var (
+	_ limiter.Eval          = Client{}
+	_ limiter.EvalSha       = Client{}
+	_ limiter.EvalRO        = Client{}
+	_ limiter.EvalShaRO     = Client{}
+	_ limiter.ScriptLoad    = Client{}
+	_ limiter.ScriptLoadKey = Client{}
+	_ limiter.FCall         = Client{}
+	_ limiter.FunctionLoad  = Client{}
+	_ limiter.Pipeline      = Client{}
+	_ limiter.Subscribe     = Client{}
+	_ limiter.Scan          = Client{}
+)
+
+// New adapts the given client.
+func New(client redis.UniversalClient) Client {
+	return Client{client}
+}
+
+// Eval implements limiter.Eval.
+func (c Client) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.UniversalClient.Eval(ctx, script, keys, args...).Result()
+}
+
+// EvalSha implements limiter.EvalSha.
+func (c Client) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return c.UniversalClient.EvalSha(ctx, sha, keys, args...).Result()
+}
+
+// EvalRO implements limiter.EvalRO.
+func (c Client) EvalRO(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.UniversalClient.EvalRO(ctx, script, keys, args...).Result()
+}
+
+// EvalShaRO implements limiter.EvalShaRO.
+func (c Client) EvalShaRO(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return c.UniversalClient.EvalShaRO(ctx, sha, keys, args...).Result()
+}
+
+// ScriptLoad implements limiter.ScriptLoad.
+func (c Client) ScriptLoad(ctx context.Context, script string) (string, error) {
+	return c.UniversalClient.ScriptLoad(ctx, script).Result()
+}
+
+// ScriptLoadKey implements limiter.ScriptLoadKey, loading the script onto only
+// the node serving the key when used with a cluster client.
+func (c Client) ScriptLoadKey(ctx context.Context, key string, script string) (string, error) {
+	if cluster, ok := c.UniversalClient.(*redis.ClusterClient); ok {
+		node, err := cluster.MasterForKey(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		return node.ScriptLoad(ctx, script).Result()
+	}
+	return c.ScriptLoad(ctx, script)
+}
+
+// NodeAddr implements limiter.ScriptLoadKey, reporting the address of the node
+// serving the key when used with a cluster client.
+func (c Client) NodeAddr(ctx context.Context, key string) (string, error) {
+	if cluster, ok := c.UniversalClient.(*redis.ClusterClient); ok {
+		node, err := cluster.MasterForKey(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		return node.Options().Addr, nil
+	}
+	return "", nil
+}
+
+// FCall implements limiter.FCall.
+func (c Client) FCall(ctx context.Context, function string, keys []string, args []any) (any, error) {
+	return c.UniversalClient.FCall(ctx, function, keys, args...).Result()
+}
+
+// FunctionLoad implements limiter.FunctionLoad.
+func (c Client) FunctionLoad(ctx context.Context, code string) (string, error) {
+	return c.UniversalClient.FunctionLoad(ctx, code).Result()
+}
+
+// EvalShaPipeline implements limiter.Pipeline.
+func (c Client) EvalShaPipeline(ctx context.Context, sha string, calls []limiter.Call) ([]any, []error) {
+	pipe := c.UniversalClient.Pipeline()
+	cmds := make([]*redis.Cmd, len(calls))
+	for i, call := range calls {
+		cmds[i] = pipe.EvalSha(ctx, sha, call.Keys, call.Args...)
+	}
+	// Errors are reported per command, so the aggregate error is redundant.
+	_, _ = pipe.Exec(ctx)
+
+	res := make([]any, len(calls))
+	errs := make([]error, len(calls))
+	for i, cmd := range cmds {
+		res[i], errs[i] = cmd.Result()
+	}
+	return res, errs
+}
+
+// Subscribe implements limiter.Subscribe.
+func (c Client) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	sub := c.UniversalClient.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
+
+// Scan implements limiter.Scan, scanning every master node when used with a
+// cluster client. The callback is never called concurrently.
+func (c Client) Scan(ctx context.Context, match string, fn func(keys []string) error) error {
+	if cluster, ok := c.UniversalClient.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node, match, func(keys []string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return fn(keys)
+			})
+		})
+	}
+	return scan(ctx, c.UniversalClient, match, fn)
+}
+
+func scan(ctx context.Context, client redis.Cmdable, match string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, match, 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
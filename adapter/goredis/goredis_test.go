@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package goredis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	"github.com/plsmphnx/go-redis-bucket/adapter/goredis"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdapter(t *testing.T) {
+	ctx := context.Background()
+	r := redis.NewClient(&redis.Options{})
+	key := "redis-bucket-test:key:" + t.Name()
+	defer r.Del(ctx, key)
+
+	l, err := limiter.New(goredis.New(r), limiter.Capacity{Window: time.Minute, Min: 1, Max: 2},
+		limiter.WithPreload())
+	assert.NoError(t, err)
+
+	// The first call is allowed, and the reply is converted as expected.
+	res, err := l.Test(ctx, key, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+
+	// Pipelined calls are converted as expected.
+	var b limiter.Batch
+	b.Test(l, key, 1)
+	b.Peek(l, key, 1)
+	results, err := b.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	// Keys are scanned and their state decoded.
+	states, err := l.Keys(ctx)
+	assert.NoError(t, err)
+	found := false
+	for _, state := range states {
+		if state.Key == key {
+			found = true
+			assert.Len(t, state.Levels, 1)
+		}
+	}
+	assert.True(t, found)
+}
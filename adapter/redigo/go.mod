@@ -0,0 +1,17 @@
+module github.com/plsmphnx/go-redis-bucket/adapter/redigo
+
+go 1.21
+
+require (
+	github.com/gomodule/redigo v1.9.2
+	github.com/plsmphnx/go-redis-bucket v0.0.0
+	github.com/stretchr/testify v1.8.4
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/plsmphnx/go-redis-bucket => ../..
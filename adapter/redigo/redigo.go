@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package redigo adapts redigo connection pools for use with the rate limiter.
+package redigo
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+// Client adapts a redigo connection pool to the interfaces used by the limiter.
+type Client struct{ *redis.Pool }
+
+var (
+	_ limiter.Eval         = Client{}
+	_ limiter.EvalSha      = Client{}
+	_ limiter.EvalRO       = Client{}
+	_ limiter.EvalShaRO    = Client{}
+	_ limiter.ScriptLoad   = Client{}
+	_ limiter.FCall        = Client{}
+	_ limiter.FunctionLoad = Client{}
+	_ limiter.Pipeline     = Client{}
+)
+
+// New adapts the given connection pool.
+func New(pool *redis.Pool) Client {
+	return Client{pool}
+}
+
+// Eval implements limiter.Eval.
+func (c Client) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.script(ctx, "EVAL", script, keys, args)
+}
+
+// EvalSha implements limiter.EvalSha.
+func (c Client) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return c.script(ctx, "EVALSHA", sha, keys, args)
+}
+
+// EvalRO implements limiter.EvalRO.
+func (c Client) EvalRO(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.script(ctx, "EVAL_RO", script, keys, args)
+}
+
+// EvalShaRO implements limiter.EvalShaRO.
+func (c Client) EvalShaRO(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return c.script(ctx, "EVALSHA_RO", sha, keys, args)
+}
+
+// FCall implements limiter.FCall.
+func (c Client) FCall(ctx context.Context, function string, keys []string, args []any) (any, error) {
+	return c.script(ctx, "FCALL", function, keys, args)
+}
+
+// ScriptLoad implements limiter.ScriptLoad.
+func (c Client) ScriptLoad(ctx context.Context, script string) (string, error) {
+	return redis.String(c.do(ctx, "SCRIPT", "LOAD", script))
+}
+
+// FunctionLoad implements limiter.FunctionLoad.
+func (c Client) FunctionLoad(ctx context.Context, code string) (string, error) {
+	return redis.String(c.do(ctx, "FUNCTION", "LOAD", code))
+}
+
+// EvalShaPipeline implements limiter.Pipeline.
+func (c Client) EvalShaPipeline(ctx context.Context, sha string, calls []limiter.Call) ([]any, []error) {
+	res := make([]any, len(calls))
+	errs := make([]error, len(calls))
+
+	conn, err := c.GetContext(ctx)
+	if err == nil {
+		defer conn.Close()
+		for _, call := range calls {
+			if err = conn.Send("EVALSHA", params(sha, call.Keys, call.Args)...); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = conn.Flush()
+		}
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return res, errs
+	}
+
+	for i := range calls {
+		res[i], errs[i] = conn.Receive()
+		res[i] = convert(res[i])
+	}
+	return res, errs
+}
+
+func (c Client) script(ctx context.Context, cmd string, script string, keys []string, args []any) (any, error) {
+	res, err := c.do(ctx, cmd, params(script, keys, args)...)
+	return convert(res), err
+}
+
+func (c Client) do(ctx context.Context, cmd string, args ...any) (any, error) {
+	conn, err := c.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return redis.DoContext(conn, ctx, cmd, args...)
+}
+
+// Scripting commands take the script, the number of keys, the keys, and then
+// any arguments.
+func params(script string, keys []string, args []any) []any {
+	params := make([]any, 0, len(keys)+len(args)+2)
+	params = append(params, script, len(keys))
+	for _, key := range keys {
+		params = append(params, key)
+	}
+	return append(params, args...)
+}
+
+// Bulk strings are returned by redigo as byte slices, whereas the limiter
+// expects them as strings.
+func convert(raw any) any {
+	switch raw := raw.(type) {
+	case []byte:
+		return string(raw)
+	case []any:
+		for i, val := range raw {
+			raw[i] = convert(val)
+		}
+		return raw
+	default:
+		return raw
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package redigo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	adapter "github.com/plsmphnx/go-redis-bucket/adapter/redigo"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdapter(t *testing.T) {
+	ctx := context.Background()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "127.0.0.1:6379") }}
+	defer pool.Close()
+
+	key := "redis-bucket-test:key:" + t.Name()
+	defer func() {
+		conn := pool.Get()
+		conn.Do("DEL", key)
+		conn.Close()
+	}()
+
+	l, err := limiter.New(adapter.New(pool), limiter.Capacity{Window: time.Minute, Min: 1, Max: 2},
+		limiter.WithPreload())
+	assert.NoError(t, err)
+
+	// The first call is allowed, and the reply is converted as expected.
+	res, err := l.Test(ctx, key, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+
+	// Pipelined calls are converted as expected.
+	var b limiter.Batch
+	b.Test(l, key, 1)
+	b.Peek(l, key, 1)
+	results, err := b.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+}
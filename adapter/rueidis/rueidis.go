@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package rueidis adapts rueidis clients for use with the rate limiter.
+//
+// Scripts are executed using the rueidis Lua helper, which handles EVALSHA and
+// its fallback to EVAL internally, and concurrent calls are automatically
+// pipelined by the client.
+package rueidis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/redis/rueidis"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+// Client adapts a rueidis client to the interfaces used by the limiter.
+type Client struct {
+	client  rueidis.Client
+	scripts sync.Map
+}
+
+type scripts struct {
+	rw *rueidis.Lua
+	ro *rueidis.Lua
+}
+
+var (
+	_ limiter.Eval         = (*Client)(nil)
+	_ limiter.EvalRO       = (*Client)(nil)
+	_ limiter.ScriptLoad   = (*Client)(nil)
+	_ limiter.FCall        = (*Client)(nil)
+	_ limiter.FunctionLoad = (*Client)(nil)
+	_ limiter.Pipeline     = (*Client)(nil)
+)
+
+// New adapts the given client.
+func New(client rueidis.Client) *Client {
+	return &Client{client: client}
+}
+
+// Eval implements limiter.Eval, using EVALSHA where the script is loaded.
+func (c *Client) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.lua(script).rw.Exec(ctx, c.client, keys, format(args)).ToAny()
+}
+
+// EvalRO implements limiter.EvalRO, using EVALSHA_RO where the script is loaded.
+func (c *Client) EvalRO(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.lua(script).ro.Exec(ctx, c.client, keys, format(args)).ToAny()
+}
+
+// ScriptLoad implements limiter.ScriptLoad.
+func (c *Client) ScriptLoad(ctx context.Context, script string) (string, error) {
+	return c.client.Do(ctx, c.client.B().ScriptLoad().Script(script).Build()).ToString()
+}
+
+// FCall implements limiter.FCall.
+func (c *Client) FCall(ctx context.Context, function string, keys []string, args []any) (any, error) {
+	cmd := c.client.B().Fcall().Function(function).Numkeys(int64(len(keys))).Key(keys...).Arg(format(args)...).Build()
+	return c.client.Do(ctx, cmd).ToAny()
+}
+
+// FunctionLoad implements limiter.FunctionLoad.
+func (c *Client) FunctionLoad(ctx context.Context, code string) (string, error) {
+	return c.client.Do(ctx, c.client.B().FunctionLoad().FunctionCode(code).Build()).ToString()
+}
+
+// EvalShaPipeline implements limiter.Pipeline.
+func (c *Client) EvalShaPipeline(ctx context.Context, sha string, calls []limiter.Call) ([]any, []error) {
+	cmds := make(rueidis.Commands, len(calls))
+	for i, call := range calls {
+		cmds[i] = c.client.B().Evalsha().Sha1(sha).Numkeys(int64(len(call.Keys))).Key(call.Keys...).Arg(format(call.Args)...).Build()
+	}
+
+	res := make([]any, len(calls))
+	errs := make([]error, len(calls))
+	for i, r := range c.client.DoMulti(ctx, cmds...) {
+		res[i], errs[i] = r.ToAny()
+	}
+	return res, errs
+}
+
+// The limiter only uses a single script, so this cache stays small.
+func (c *Client) lua(script string) *scripts {
+	if s, ok := c.scripts.Load(script); ok {
+		return s.(*scripts)
+	}
+	s, _ := c.scripts.LoadOrStore(script, &scripts{
+		rw: rueidis.NewLuaScript(script),
+		ro: rueidis.NewLuaScriptReadOnly(script),
+	})
+	return s.(*scripts)
+}
+
+// Arguments are formatted in the same way as go-redis, for consistency.
+func format(args []any) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			strs[i] = arg
+		case int:
+			strs[i] = strconv.Itoa(arg)
+		case float64:
+			strs[i] = strconv.FormatFloat(arg, 'f', -1, 64)
+		default:
+			strs[i] = fmt.Sprint(arg)
+		}
+	}
+	return strs
+}
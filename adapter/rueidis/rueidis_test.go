@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package rueidis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	adapter "github.com/plsmphnx/go-redis-bucket/adapter/rueidis"
+
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdapter(t *testing.T) {
+	ctx := context.Background()
+	r, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{"127.0.0.1:6379"}})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	key := "redis-bucket-test:key:" + t.Name()
+	defer r.Do(ctx, r.B().Del().Key(key).Build())
+
+	l, err := limiter.New(adapter.New(r), limiter.Capacity{Window: time.Minute, Min: 1, Max: 2})
+	assert.NoError(t, err)
+
+	// The first call is allowed, and the reply is converted as expected.
+	res, err := l.Test(ctx, key, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+
+	// Read-only and pipelined calls are converted as expected.
+	_, err = l.Peek(ctx, key, 1)
+	assert.NoError(t, err)
+
+	var b limiter.Batch
+	b.Test(l, key, 1)
+	b.Peek(l, key, 1)
+	results, err := b.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Decides tests locally from the most recent results returned by Redis,
+	// while updating Redis in the background.
+	async struct {
+		mu    sync.Mutex
+		keys  map[string]*view
+		slots chan struct{}
+		sweep time.Time
+	}
+
+	view struct {
+		free    float64
+		flow    float64
+		until   time.Time
+		updated time.Time
+	}
+)
+
+// The number of background calls to Redis which may be in flight at once;
+// any further updates are dropped until these complete.
+const asyncSlots = 64
+
+// How long a cached view is kept after its last update.
+const asyncExpiry = time.Minute
+
+// WithAsync decides each test immediately from a locally cached view of the
+// key, which is then updated by a call to Redis in the background. This is
+// suited to latency-critical paths which can tolerate some over-admission, as
+// keys are allowed until Redis reports otherwise. This applies only to Test.
+func WithAsync() Config {
+	return func(c *config) { c.async = true }
+}
+
+func newAsync() *async {
+	return &async{keys: map[string]*view{}, slots: make(chan struct{}, asyncSlots)}
+}
+
+// Tests which are allowed locally are charged in the background, whereas those
+// which are denied only refresh the view of the key. The flow of the limiting
+// bucket is kept with the view, so that local denials report a wait.
+func (a *async) do(key string, cost float64, test func(context.Context, float64) (Result, error), peek func(context.Context, float64) (Result, error), flow func(bucket int) float64) Result {
+	now := time.Now()
+	res := a.decide(key, cost, now)
+	if !res.Allow {
+		test = peek
+	}
+
+	select {
+	case a.slots <- struct{}{}:
+		go func() {
+			defer func() { <-a.slots }()
+			if res, err := test(context.Background(), cost); err == nil {
+				a.update(key, res, flow(res.Bucket), time.Now())
+			}
+		}()
+	default:
+	}
+	return res
+}
+
+func (a *async) decide(key string, cost float64, now time.Time) Result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Stale views are removed periodically, rather than on every call.
+	if now.Sub(a.sweep) >= asyncExpiry {
+		a.sweep = now
+		for k, v := range a.keys {
+			if now.Sub(v.updated) >= asyncExpiry {
+				delete(a.keys, k)
+			}
+		}
+	}
+
+	v, ok := a.keys[key]
+	if !ok {
+		return Result{Allow: true}
+	}
+	if now.Before(v.until) {
+		return Result{Allow: false, Wait: v.until.Sub(now)}
+	}
+	if v.free < cost {
+		// Wait for the shortfall to drain, as with an exact wait.
+		res := Result{Allow: false}
+		if v.flow > 0 {
+			res.Wait = seconds((cost - v.free) / v.flow)
+		}
+		return res
+	}
+	v.free -= cost
+	return Result{Allow: true, Free: v.free}
+}
+
+func (a *async) update(key string, res Result, flow float64, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if res.Allow {
+		a.keys[key] = &view{free: res.Free, flow: flow, updated: now}
+	} else {
+		a.keys[key] = &view{until: now.Add(res.Wait), updated: now}
+	}
+}
+
+func (a *async) forget(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.keys, key)
+}
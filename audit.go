@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "errors"
+
+type audit struct {
+	stream string
+	maxLen int
+}
+
+// WithAuditStream appends each denial to the given Redis Stream, capped at
+// approximately the given length, within the same call which evaluates it.
+// Each entry records the time, key, cost and denying bucket (0 for the penalty
+// box). The stream is shared by every key, so this cannot be used with
+// WithCluster.
+func WithAuditStream(stream string, maxLen int) Config {
+	return func(c *config) { c.audit = audit{stream, maxLen} }
+}
+
+func (a audit) args() ([]any, error) {
+	if a.stream == "" {
+		return nil, nil
+	}
+	if a.maxLen <= 0 {
+		return nil, errors.New("limiter: audit stream length must be positive")
+	}
+	return []any{"audit", a.maxLen}, nil
+}
+
+// Append the keys of a call to the given slice, followed by the ban records,
+// quota usage and metered usage (of the given kinds) of the first limited
+// keys, weights hash, sets of active keys and their demand and audit stream,
+// if any, in the order in which the script expects them.
+func (l *Limiter) audited(all []string, keys []string, limited int, quota string, meter string) []string {
+	all = append(all, keys...)
+	if l.banning {
+		for _, key := range keys[:limited] {
+			all = append(all, banKey(key))
+		}
+	}
+	if quota != "" {
+		for _, key := range keys[:limited] {
+			all = append(all, derive(key, quota))
+		}
+	}
+	if meter != "" {
+		for _, key := range keys[:limited] {
+			all = append(all, derive(key, meter))
+		}
+	}
+	if l.weights != "" {
+		all = append(all, string(l.weights))
+	}
+	if l.fair != "" {
+		all = append(all, l.fair, derive(l.fair, "demand"))
+	}
+	if l.audit != "" {
+		all = append(all, l.audit)
+	}
+	return all
+}
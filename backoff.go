@@ -3,7 +3,12 @@
 
 package limiter
 
-import "math"
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
 
 // WithConstantBackoff applies a constant backoff to the limiter.
 func WithConstantBackoff(factor float64) Config {
@@ -33,9 +38,99 @@ func WithExponentialBackoff(factor float64) Config {
 	}
 }
 
+// WithFibonacciBackoff applies a Fibonacci backoff to the limiter, which grows
+// faster than linear but slower than exponential.
+func WithFibonacciBackoff(factor float64) Config {
+	return func(c *config) {
+		c.backoff = func(deny float64) float64 {
+			// Binet's formula avoids iterating over every denial; it is exact
+			// while the numbers fit a float, and infinite once they overflow.
+			n := math.Max(1, math.Ceil(deny))
+			return factor * math.Round(math.Pow(math.Phi, n)/math.Sqrt(5))
+		}
+	}
+}
+
+// WithDecorrelatedJitterBackoff applies a decorrelated jitter backoff to the
+// limiter, choosing each wait at random between the base wait and the factor
+// (typically 3) times the previous wait, which is estimated from the number of
+// denials. This spreads out retries more than a fixed backoff with jitter; it
+// should usually be combined with WithMaxWait.
+func WithDecorrelatedJitterBackoff(factor float64) Config {
+	return func(c *config) {
+		c.backoff = func(deny float64) float64 {
+			return 1 + rand.Float64()*math.Max(0, factor*deny-1)
+		}
+	}
+}
+
+// WithExactBackoff sets each wait to exactly the time until the cost would fit
+// in every bucket, rather than scaling it by the number of denials, so that
+// well-behaved clients retrying after the wait are allowed. This replaces any
+// other backoff.
+func WithExactBackoff() Config {
+	return func(c *config) { c.backoff, c.exact = nil, true }
+}
+
+// WithBucketBackoff applies the given backoff (such as WithExponentialBackoff)
+// to denials by the given bucket only, in place of the backoff of the limiter,
+// so that each bucket may suggest waits suited to its window.
+func WithBucketBackoff(bucket Bucket, backoff Config) Config {
+	return func(c *config) {
+		var b config
+		backoff(&b)
+		if b.backoff == nil && !b.exact && c.err == nil {
+			c.err = errors.New("limiter: bucket backoff must be a backoff")
+		}
+		if c.backoffs == nil {
+			c.backoffs = map[Rate]func(float64) float64{}
+		}
+		// An exact backoff is recorded as absent, as for the limiter.
+		flow, burst := bucket.Rate()
+		c.backoffs[Rate{flow, burst}] = b.backoff
+	}
+}
+
+// Resolve the backoff of each bucket, numbered as in Result, so that it is found
+// by the index of the denying bucket however its rate is later transformed
+// (such as by integer precision or sharding).
+func resolveBackoffs(rates []Rate, backoff func(float64) float64, byRate map[Rate]func(float64) float64) []func(float64) float64 {
+	kept, _ := effectiveRates(rates)
+	backoffs := make([]func(float64) float64, len(kept))
+	for n, r := range kept {
+		if b, ok := byRate[r]; ok {
+			backoffs[n] = b
+		} else {
+			backoffs[n] = backoff
+		}
+	}
+	return backoffs
+}
+
 // WithCustomBackoff applies a custom backoff to the limiter.
 func WithCustomBackoff(backoff func(float64) float64) Config {
 	return func(c *config) {
 		c.backoff = backoff
 	}
 }
+
+// WithJitter randomizes each wait computed by the backoff by up to the given
+// fraction in either direction, so that clients denied at the same time do not
+// all retry at the same time. The fraction must be between 0 and 1.
+func WithJitter(fraction float64) Config {
+	return func(c *config) { c.jitter = fraction }
+}
+
+func jitter(wait float64, fraction float64) float64 {
+	if fraction == 0 {
+		return wait
+	}
+	return wait * (1 + fraction*(2*rand.Float64()-1))
+}
+
+// WithMaxWait caps each wait computed by the backoff at the given duration,
+// so that heavily denied keys are not told to wait for excessive periods. It
+// applies to any backoff, after any jitter, but not to the penalty box.
+func WithMaxWait(max time.Duration) Config {
+	return func(c *config) { c.maxWait = max }
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type banning struct {
+	rate     float64
+	period   time.Duration
+	duration time.Duration
+}
+
+const (
+	banSet   = `return redis.call('set', KEYS[1], 1, 'px', ARGV[1])`
+	banCheck = `return redis.call('pttl', KEYS[1])`
+	banClear = `return redis.call('del', KEYS[1])`
+)
+
+// WithBanning bans a key for the given duration once it has sustained denials
+// costing more than the given rate (per second) for the given period. A ban is
+// recorded in Redis with a TTL, alongside the key; while it remains, all calls
+// for that key are denied immediately without evaluating any buckets, and the
+// wait indicates the time remaining until the ban expires. Keys may also be
+// banned and unbanned explicitly, using Ban and Unban.
+func WithBanning(rate float64, period time.Duration, duration time.Duration) Config {
+	return func(c *config) { c.banning = banning{rate, period, duration} }
+}
+
+func (b banning) args() ([]any, error) {
+	if b == (banning{}) {
+		return nil, nil
+	}
+	if b.rate <= 0 || b.period <= 0 || b.duration <= 0 {
+		return nil, errors.New("limiter: banning parameters must be positive")
+	}
+	return []any{"ban", b.rate, "ban_period", b.period.Seconds(), "ban_ttl", b.duration.Seconds()}, nil
+}
+
+// The key holding the ban record of the given (prefixed) key.
+func banKey(key string) string {
+	return derive(key, "ban")
+}
+
+// Ban bans the given key for the given duration, as with WithBanning.
+func (l *Limiter) Ban(ctx context.Context, key string, duration time.Duration) error {
+	if !l.banning {
+		return errors.New("limiter: banning is not enabled")
+	}
+	if duration < time.Millisecond {
+		return errors.New("limiter: ban duration must be at least a millisecond")
+	}
+	_, err := l.redis.Eval(ctx, banSet, []string{banKey(l.key(key))}, []any{duration.Milliseconds()})
+	return err
+}
+
+// Unban lifts any ban of the given key.
+func (l *Limiter) Unban(ctx context.Context, key string) error {
+	if !l.banning {
+		return errors.New("limiter: banning is not enabled")
+	}
+	_, err := l.redis.Eval(ctx, banClear, []string{banKey(l.key(key))}, nil)
+	return err
+}
+
+// IsBanned reports whether the given key is currently banned, and if so, the
+// time remaining until the ban expires.
+func (l *Limiter) IsBanned(ctx context.Context, key string) (bool, time.Duration, error) {
+	if !l.banning {
+		return false, 0, errors.New("limiter: banning is not enabled")
+	}
+	raw, err := l.redis.Eval(ctx, banCheck, []string{banKey(l.key(key))}, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	ttl, ok := raw.(int64)
+	if !ok {
+		return false, 0, errInvalidReply
+	}
+	if ttl <= 0 {
+		return false, 0, nil
+	}
+	return true, time.Duration(ttl) * time.Millisecond, nil
+}
@@ -3,7 +3,11 @@
 
 package limiter
 
-import "time"
+import (
+	"errors"
+	"math"
+	"time"
+)
 
 type (
 	// Bucket represents a single set of rate-limiting parameters that can be
@@ -16,6 +20,7 @@ type (
 	Rate struct {
 		// Flow is the rate at which capacity becomes available, per second. In
 		// a fully-stressed system, calls will be limited to exactly this rate.
+		// An infinite flow never limits calls, whatever the burst.
 		Flow float64
 
 		// Burst is the amount of leeway in capacity the system can support.
@@ -42,6 +47,13 @@ type (
 	}
 )
 
+// Unlimited is a bucket which never limits calls, so that a limiter may be
+// configured without limits (such as for a plan tier which has none) rather
+// than omitted. It is ignored alongside other buckets; a limiter with only
+// unlimited buckets, or a schedule window with only unlimited buckets, allows
+// every call without calling Redis, as with WithBypass.
+var Unlimited = Rate{Flow: math.Inf(1)}
+
 // Rate returns the flow and burst parameters for a Rate bucket.
 func (r Rate) Rate() (float64, float64) {
 	return r.Flow, r.Burst
@@ -52,10 +64,54 @@ func (c Capacity) Rate() (float64, float64) {
 	return c.Min / c.Window.Seconds(), c.Max - c.Min
 }
 
+// Whether the rate never limits calls.
+func (r Rate) unlimited() bool {
+	return math.IsInf(r.Flow, 1)
+}
+
+func (r Rate) validate() error {
+	if r.unlimited() {
+		return nil
+	}
+	if r.Flow <= 0 || r.Burst <= 0 {
+		return errors.New("limiter: rate parameters must be positive")
+	}
+	return nil
+}
+
+func (c Capacity) validate() error {
+	if c.Window <= 0 {
+		return errors.New("limiter: capacity window must be positive")
+	}
+	if c.Min <= 0 {
+		return errors.New("limiter: capacity minimum must be positive")
+	}
+	if c.Max <= c.Min {
+		return errors.New("limiter: capacity maximum must exceed minimum")
+	}
+	return nil
+}
+
 // WithAdditionalBucket adds an additional rate-limiting bucket to the limiter.
 func WithAdditionalBucket(bucket Bucket) Config {
 	return func(c *config) {
+		if v, ok := bucket.(interface{ validate() error }); ok && c.err == nil {
+			c.err = v.validate()
+		}
 		flow, burst := bucket.Rate()
 		c.rates = append(c.rates, Rate{flow, burst})
 	}
 }
+
+// Buckets returns the rates currently being enforced, numbered as in Result
+// from slowest to fastest flow, after any superfluous or unlimited buckets are
+// removed and any schedule is applied; it is empty if there are no limits. With
+// integer precision, the rates are rounded as they are enforced.
+func (l *Limiter) Buckets() []Rate {
+	s := l.current()
+	rates := make([]Rate, s.rates/2)
+	for n := range rates {
+		rates[n] = s.rate(n)
+	}
+	return rates
+}
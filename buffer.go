@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"math"
+	"strconv"
+	"sync"
+)
+
+// buffer holds the keys and arguments of a single call to the script, pooled
+// so that they need not be allocated for every call.
+type buffer struct {
+	keys []string
+	args []any
+}
+
+var buffers = sync.Pool{New: func() any { return &buffer{} }}
+
+// Costs which are small whole numbers are by far the most common, so they are
+// boxed in advance, rather than on every call.
+var floatCosts, microCosts = func() (floats [256]any, micros [256]any) {
+	for i := range floats {
+		floats[i], micros[i] = float64(i), int64(i*microUnits)
+	}
+	return
+}()
+
+// The script argument for the given cost.
+func (s *settings) cost(cost float64) any {
+	if i := int(cost); float64(i) == cost && i >= 0 && i < len(floatCosts) {
+		if s.integer {
+			return microCosts[i]
+		}
+		return floatCosts[i]
+	}
+	if s.integer {
+		return int64(math.Round(cost * microUnits))
+	}
+	return cost
+}
+
+// Encode the static script arguments as they are sent to Redis, so that the
+// client need not format the numbers again on every call.
+func encode(args []any) []any {
+	wire := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case float64:
+			wire[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		case int64:
+			wire[i] = strconv.FormatInt(v, 10)
+		case int:
+			wire[i] = strconv.Itoa(v)
+		default:
+			wire[i] = v
+		}
+	}
+	return wire
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "math"
+
+// WithBypass exempts any key for which the given predicate returns true, such
+// as those of health checks, internal service accounts and smoke tests. Tests
+// of exempt keys are always allowed, with infinite remaining capacity, without
+// calling Redis. Calls to Test for exempt keys are still reported to any hooks
+// and logger.
+func WithBypass(exempt func(key string) bool) Config {
+	return func(c *config) { c.bypass = exempt }
+}
+
+// Whether every one of the given (unprefixed) keys is exempt.
+func (l *Limiter) bypassed(keys ...string) bool {
+	if l.bypass == nil {
+		return false
+	}
+	for _, key := range keys {
+		if !l.bypass(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// The result of a test which is not limited.
+func unlimited() Result {
+	return Result{Allow: true, Free: math.Inf(1)}
+}
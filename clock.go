@@ -0,0 +1,37 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "time"
+
+// Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock supplies the current time to the script from the given clock,
+// rather than using the time of the Redis server. This allows deterministic
+// tests, and use with servers which do not support TIME within scripts. The
+// clocks of every instance sharing a key should be closely synchronized, and
+// keys still expire according to the time of the server.
+func WithClock(clock Clock) Config {
+	return func(c *config) { c.clock = clock }
+}
+
+// The current time, according to the clock if any.
+func (l *Limiter) now() time.Time {
+	if l.clock != nil {
+		return l.clock.Now()
+	}
+	return time.Now()
+}
+
+// Append the current time to the options of a call, if a clock is supplied.
+func (l *Limiter) clocked(opts []any) []any {
+	if l.clock == nil {
+		return opts
+	}
+	now := float64(l.clock.Now().UnixNano()) / 1e9
+	return append(opts[:len(opts):len(opts)], "now", now)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"strings"
+)
+
+// WithHashTags wraps each key in a Redis Cluster hash tag, after the prefix, so
+// that any keys derived from it (such as for deduplication) share its slot.
+func WithHashTags() Config {
+	return func(c *config) { c.tags = true }
+}
+
+// WithCluster validates that the keys used by any single call all belong to
+// the same Redis Cluster slot, as is required for them to be used together by
+// one script. Keys passed to TestKeys must share a hash tag for this to hold.
+// The audit stream, weights hash and fair sharing set are shared by every key,
+// so they would belong to other slots, and cannot be used with a cluster.
+func WithCluster() Config {
+	return func(c *config) { c.cluster = true }
+}
+
+// Check that no keys shared between all keys are used with a cluster.
+func (c *config) validateCluster() error {
+	if c.cluster && (c.audit.stream != "" || c.weights != "" || c.fair.set != "") {
+		return errors.New("limiter: audit, weights and fair sharing cannot be used with a cluster")
+	}
+	return nil
+}
+
+func (l *Limiter) key(key string) string {
+	return l.stored(l.id(key))
+}
+
+// The key stored in Redis for the given identifier, as reported when scanning.
+func (l *Limiter) stored(id string) string {
+	return l.wrap(escapeKey(id))
+}
+
+// Apply the prefix and any hash tag to an escaped key.
+func (l *Limiter) wrap(key string) string {
+	if l.tags {
+		return l.prefix + "{" + key + "}"
+	}
+	return l.prefix + key
+}
+
+// Recover the key as passed to Test from the key stored in Redis, reporting
+// false if it was not produced by key.
+func (l *Limiter) unkey(key string) (string, bool) {
+	key, ok := l.unwrap(key)
+	if !ok {
+		return "", false
+	}
+	return unescapeKey(key)
+}
+
+// Reverse wrap, reporting false if the key lacks the prefix or hash tag.
+func (l *Limiter) unwrap(key string) (string, bool) {
+	key, ok := strings.CutPrefix(key, l.prefix)
+	if ok && l.tags {
+		if !strings.HasPrefix(key, "{") || !strings.HasSuffix(key, "}") {
+			return "", false
+		}
+		key = key[1 : len(key)-1]
+	}
+	return key, ok
+}
+
+// Records derived from a key, such as its ban, follow the key after a single
+// NUL and the kind of record. NULs within keys are doubled, so that no key can
+// be mistaken for a record derived from another.
+func derive(key string, kind string) string {
+	return key + "\x00" + kind
+}
+
+func escapeKey(key string) string {
+	if strings.IndexByte(key, 0) < 0 {
+		return key
+	}
+	return strings.ReplaceAll(key, "\x00", "\x00\x00")
+}
+
+// Split a record derived from an escaped key into the key and the kind of
+// record, reporting false if it is not derived from a key.
+func underive(key string) (string, string, bool) {
+	for i := 0; i < len(key); i++ {
+		if key[i] == 0 {
+			if i+1 < len(key) && key[i+1] == 0 {
+				i++
+				continue
+			}
+			return key[:i], key[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// Reverse escapeKey, reporting false for records derived from a key.
+func unescapeKey(key string) (string, bool) {
+	if strings.IndexByte(key, 0) < 0 {
+		return key, true
+	}
+	b := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		if key[i] == 0 {
+			if i+1 == len(key) || key[i+1] != 0 {
+				return "", false
+			}
+			i++
+		}
+		b = append(b, key[i])
+	}
+	return string(b), true
+}
+
+func (l *Limiter) validateSlots(keys []string) error {
+	if l.cluster {
+		for _, key := range keys[1:] {
+			if slot(key) != slot(keys[0]) {
+				return errors.New("limiter: keys must belong to the same cluster slot")
+			}
+		}
+	}
+	return nil
+}
+
+// Compute the cluster slot of a key, as described by the cluster specification.
+func slot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return crc16(key) % 16384
+}
+
+// CRC16-CCITT (XMODEM), as used for cluster key slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Command redis-bucket inspects and administers rate-limiting state in Redis.
+// The buckets and key prefix are read from the same environment variables as
+// limiter.ConfigFromEnv, so that it sees keys exactly as the services do.
+//
+// Usage:
+//
+//	redis-bucket [flags] state KEY      Show the state of a key.
+//	redis-bucket [flags] keys           Show the state of every key.
+//	redis-bucket [flags] test KEY COST  Report whether a cost would be allowed,
+//	                                    without consuming any capacity.
+//	redis-bucket [flags] reset PATTERN  Delete every key matching a pattern.
+//	redis-bucket [flags] load           Load the script into Redis.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "redis-bucket:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("redis-bucket", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:6379", "the address of the Redis server")
+	env := flags.String("env", "LIMITER", "the prefix of the environment variables")
+	tags := flags.Bool("tags", false, "whether keys are wrapped in hash tags")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("missing command")
+	}
+
+	bucket, config, err := limiter.ConfigFromEnv(*env)
+	if err != nil {
+		return err
+	}
+	configs := []limiter.Config{config}
+	if *tags {
+		configs = append(configs, limiter.WithHashTags())
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: *addr})
+	defer rdb.Close()
+	l, err := limiter.New(client{rdb}, bucket, configs...)
+	if err != nil {
+		return err
+	}
+
+	switch cmd, args := args[0], args[1:]; {
+	case cmd == "state" && len(args) == 1:
+		state, err := l.State(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		printState(out, state)
+	case cmd == "keys" && len(args) == 0:
+		states, err := l.Keys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			printState(out, state)
+		}
+	case cmd == "test" && len(args) == 2:
+		cost, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid cost: %s", args[1])
+		}
+		res, err := l.Peek(ctx, args[0], cost)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "allow=%t free=%g wait=%s reset=%s bucket=%d\n",
+			res.Allow, res.Free, res.Wait, res.Reset, res.Bucket)
+	case cmd == "reset" && len(args) == 1:
+		n, err := l.ResetAll(ctx, args[0])
+		fmt.Fprintf(out, "deleted %d keys\n", n)
+		return err
+	case cmd == "load" && len(args) == 0:
+		return l.Preload(ctx)
+	default:
+		return fmt.Errorf("invalid command: %v", append([]string{cmd}, args...))
+	}
+	return nil
+}
+
+func printState(out io.Writer, state limiter.KeyState) {
+	fmt.Fprintf(out, "%s levels=%v free=%g penalty=%s ttl=%s\n",
+		strconv.Quote(state.Key), state.Levels, state.Free, state.Penalty, state.TTL)
+}
+
+// client adapts the go-redis client to the interfaces used by the limiter.
+type client struct{ *redis.Client }
+
+func (c client) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.Client.Eval(ctx, script, keys, args...).Result()
+}
+
+func (c client) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return c.Client.EvalSha(ctx, sha, keys, args...).Result()
+}
+
+func (c client) ScriptLoad(ctx context.Context, script string) (string, error) {
+	return c.Client.ScriptLoad(ctx, script).Result()
+}
+
+func (c client) Scan(ctx context.Context, match string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.Client.Scan(ctx, cursor, match, 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
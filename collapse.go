@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "sync"
+
+type (
+	// Collapses concurrent tests of the same key. While a test of a key is in
+	// flight, any further tests of that key are queued, and then sent together
+	// as a single test of their summed cost once the first completes. Queued
+	// tests are split into several flights where their summed cost would not
+	// be valid, such as by exceeding a burst.
+	collapser struct {
+		mu      sync.Mutex
+		active  map[string]bool
+		pending map[string][]*flight
+	}
+
+	flight struct {
+		cost  float64
+		ready chan struct{}
+		done  chan struct{}
+		res   Result
+		err   error
+	}
+)
+
+// WithCollapsing collapses concurrent tests of the same key into a single call
+// to Redis with their summed cost, sharing the result between all of them,
+// which greatly reduces the load from frequently tested keys. As the shared
+// result is for the summed cost, either all of the tests are allowed or none
+// of them are. This applies only to Test.
+func WithCollapsing() Config {
+	return func(c *config) { c.collapse = true }
+}
+
+func newCollapser() *collapser {
+	return &collapser{active: map[string]bool{}, pending: map[string][]*flight{}}
+}
+
+func (c *collapser) do(key string, cost float64, check func(float64) error, test func(float64) (Result, error)) (Result, error) {
+	// Each cost is checked on its own, so that an invalid cost fails alone.
+	if err := check(cost); err != nil {
+		return Result{}, err
+	}
+
+	c.mu.Lock()
+	if !c.active[key] {
+		c.active[key] = true
+		c.mu.Unlock()
+		defer c.finish(key)
+		return test(cost)
+	}
+
+	// The last queued flight is joined if the summed cost is still valid.
+	queue := c.pending[key]
+	var f *flight
+	if n := len(queue); n > 0 && check(queue[n-1].cost+cost) == nil {
+		f = queue[n-1]
+	}
+	first := f == nil
+	if first {
+		f = &flight{ready: make(chan struct{}), done: make(chan struct{})}
+		c.pending[key] = append(queue, f)
+	}
+	f.cost += cost
+	c.mu.Unlock()
+
+	// The first queued test sends the combined test once it is ready.
+	if first {
+		<-f.ready
+		f.res, f.err = test(f.cost)
+		close(f.done)
+		c.finish(key)
+	}
+
+	<-f.done
+	return f.res, f.err
+}
+
+// Start the next queued flight for the key, if there is one.
+func (c *collapser) finish(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if queue := c.pending[key]; len(queue) > 0 {
+		if len(queue) == 1 {
+			delete(c.pending, key)
+		} else {
+			c.pending[key] = queue[1:]
+		}
+		close(queue[0].ready)
+	} else {
+		delete(c.active, key)
+	}
+}
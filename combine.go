@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	// Tester represents anything which tests actions against rate limits, such
+	// as a Limiter, Profiles or a Composite.
+	Tester interface {
+		Test(ctx context.Context, key string, cost float64) (Result, error)
+	}
+
+	// TesterFunc adapts a function to a Tester, such as to derive a different
+	// key (for example, the client address rather than the user) for one of
+	// the limiters in a Composite.
+	TesterFunc func(ctx context.Context, key string, cost float64) (Result, error)
+
+	// Composite tests an action against several limiters, each keyed
+	// independently, merging their results.
+	Composite struct {
+		testers []Tester
+		any     bool
+	}
+)
+
+// Test calls the function.
+func (f TesterFunc) Test(ctx context.Context, key string, cost float64) (Result, error) {
+	return f(ctx, key, cost)
+}
+
+// All combines the given limiters, such that an action is allowed only if
+// every one of them allows it. They are tested in order, stopping at the first
+// denial, which is returned; the limiters before it will already have consumed
+// the cost, so the most restrictive should come first. If every limiter allows
+// the action, the result reports the least remaining capacity and the longest
+// reset. Where the limits share a Redis slot, TestKeys is atomic instead.
+func All(testers ...Tester) *Composite {
+	return &Composite{testers: testers}
+}
+
+// Any combines the given limiters, such that an action is allowed if any one
+// of them allows it. They are tested in order, stopping at the first which
+// allows the action, which is returned; the limiters before it will already
+// have recorded the denial. If every limiter denies the action, the result
+// with the shortest wait is returned.
+func Any(testers ...Tester) *Composite {
+	return &Composite{testers: testers, any: true}
+}
+
+// Test tests the given action against the combined limiters.
+func (c *Composite) Test(ctx context.Context, key string, cost float64) (Result, error) {
+	if len(c.testers) == 0 {
+		return Result{}, errors.New("limiter: must have at least one limiter")
+	}
+
+	var merged Result
+	for i, t := range c.testers {
+		res, err := t.Test(ctx, key, cost)
+		if err != nil {
+			return Result{}, err
+		}
+		if res.Allow == c.any {
+			return res, nil
+		}
+		if i == 0 {
+			merged = res
+			continue
+		}
+
+		if c.any {
+			// Keep the denial which will be lifted soonest.
+			if res.Wait < merged.Wait {
+				merged = res
+			}
+			continue
+		}
+
+		// Keep the capacity of the most restrictive limiter, and the latest reset.
+		reset := merged.Reset
+		if res.Free < merged.Free {
+			merged = res
+		}
+		if reset > merged.Reset {
+			merged.Reset = reset
+		}
+	}
+	return merged, nil
+}
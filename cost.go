@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"math"
+	"strconv"
+)
+
+// CostError indicates that a cost is invalid, either because it is negative or
+// because it exceeds the burst of a bucket, so that it could never be allowed
+// however long the caller waits. Such requests should be rejected permanently
+// rather than retried.
+type CostError struct {
+	Cost float64
+
+	// Burst is the burst which the cost exceeds, or zero if it is negative.
+	Burst float64
+}
+
+func (e *CostError) Error() string {
+	if e.Burst == 0 {
+		return "limiter: cost " + strconv.FormatFloat(e.Cost, 'g', -1, 64) + " is negative"
+	}
+	return "limiter: cost " + strconv.FormatFloat(e.Cost, 'g', -1, 64) +
+		" exceeds burst " + strconv.FormatFloat(e.Burst, 'g', -1, 64)
+}
+
+// Check that the cost could ever be allowed by every bucket.
+func (s *settings) check(cost float64) error {
+	if !(cost >= 0) {
+		return &CostError{Cost: cost}
+	}
+	for n := 0; n < s.rates/2; n++ {
+		if burst := s.rate(n).Burst; cost > burst {
+			return &CostError{cost, burst}
+		}
+	}
+	return nil
+}
+
+// The smallest burst of any bucket, which bounds the cost of any single call.
+func (s *settings) burst() float64 {
+	burst := math.Inf(1)
+	for n := 0; n < s.rates/2; n++ {
+		burst = math.Min(burst, s.rate(n).Burst)
+	}
+	return burst
+}
@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type dedup time.Duration
+
+// WithDeduplication enables TestOnce, remembering allowed request IDs for the
+// given duration so that retried requests are not charged multiple times.
+func WithDeduplication(ttl time.Duration) Config {
+	return func(c *config) { c.dedup = dedup(ttl) }
+}
+
+func (d dedup) args() ([]any, error) {
+	if d < 0 {
+		return nil, errors.New("limiter: deduplication duration must be positive")
+	}
+	// The option is only passed to the script by TestOnce.
+	return nil, nil
+}
+
+// TestOnce tests whether the given action should be allowed, as with Test,
+// except that an action which has already been allowed with the same request
+// ID is allowed again without being charged. The deduplication is performed
+// atomically with the update of the rate limits.
+func (l *Limiter) TestOnce(ctx context.Context, key string, id string, cost float64) (Result, error) {
+	if l.dedup == 0 {
+		return Result{}, errors.New("limiter: deduplication is not enabled")
+	}
+	if l.bypassed(key) {
+		return unlimited(), nil
+	}
+	keys := []string{l.key(key), derive(l.key(key), "dedup:"+id)}
+	return l.test(ctx, key, l.current(), keys, cost, false, "dedup", time.Duration(l.dedup).Seconds())
+}
@@ -4,13 +4,13 @@
 //	package main
 //
 //	import (
-//		"context"
 //		"net/http"
 //		"strconv"
 //		"time"
 //
-//		"github.com/go-redis/redis/v8"
+//		"github.com/redis/go-redis/v9"
 //		limiter "github.com/plsmphnx/go-redis-bucket"
+//		"github.com/plsmphnx/go-redis-bucket/adapter/goredis"
 //	)
 //
 //	func main() {
@@ -18,7 +18,7 @@
 //		r := redis.NewClient(&redis.Options{})
 //
 //		// Create a limiter that restricts calls to 10-20 per minute.
-//		l, err := limiter.New(Redis{r}, limiter.Capacity{Window: time.Minute, Min: 10, Max: 20})
+//		l, err := limiter.New(goredis.New(r), limiter.Capacity{Window: time.Minute, Min: 10, Max: 20})
 //		if err != nil {
 //			panic(err)
 //		}
@@ -39,13 +39,6 @@
 //		http.ListenAndServe(":80", nil)
 //	}
 //
-//	type Redis struct{ *redis.Client }
-//
-//	func (r Redis) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
-//		return r.Client.Eval(ctx, script, keys, args...).Result()
-//	}
-//
-//	func (r Redis) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
-//		return r.Client.EvalSha(ctx, sha, keys, args...).Result()
-//	}
+// Other clients can be used by implementing the Eval interface, along with any
+// of the optional interfaces (such as EvalSha) that they support.
 package limiter
@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFromEnv builds buckets and other configuration from the environment
+// variables with the given prefix, returning the first bucket and a Config
+// applying the remainder, to be passed to New. The variables are:
+//
+//	<prefix>_BUCKETS     A comma-separated list of buckets, each either a
+//	                     Capacity as "MIN-MAX/WINDOW" (such as "10-20/1m") or a
+//	                     Rate as "FLOW:BURST" (such as "0.5:10"), or
+//	                     "unlimited" for no limit. Required.
+//	<prefix>_BACKOFF     The backoff as "KIND:FACTOR", where the kind is one of
+//	                     constant, linear, power, exponential, fibonacci
+//	                     or decorrelated. Optional.
+//	<prefix>_KEY_PREFIX  The prefix added to all keys. Optional.
+func ConfigFromEnv(prefix string) (Bucket, Config, error) {
+	raw, ok := os.LookupEnv(prefix + "_BUCKETS")
+	if !ok || strings.TrimSpace(raw) == "" {
+		return nil, nil, errors.New("limiter: " + prefix + "_BUCKETS is not set")
+	}
+	var buckets []Bucket
+	for _, b := range strings.Split(raw, ",") {
+		bucket, err := parseBucket(strings.TrimSpace(b))
+		if err != nil {
+			return nil, nil, errors.New("limiter: invalid bucket in " + prefix + "_BUCKETS: " + b)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	var configs []Config
+	for _, b := range buckets[1:] {
+		configs = append(configs, WithAdditionalBucket(b))
+	}
+	if raw, ok := os.LookupEnv(prefix + "_BACKOFF"); ok {
+		backoff, err := parseBackoff(raw)
+		if err != nil {
+			return nil, nil, errors.New("limiter: invalid " + prefix + "_BACKOFF: " + raw)
+		}
+		configs = append(configs, backoff)
+	}
+	if raw, ok := os.LookupEnv(prefix + "_KEY_PREFIX"); ok {
+		configs = append(configs, WithPrefix(raw))
+	}
+
+	return buckets[0], func(c *config) {
+		for _, cfg := range configs {
+			cfg(c)
+		}
+	}, nil
+}
+
+func parseBucket(s string) (Bucket, error) {
+	if s == "unlimited" {
+		return Unlimited, nil
+	}
+	if amounts, window, ok := strings.Cut(s, "/"); ok {
+		min, max, ok := strings.Cut(amounts, "-")
+		if !ok {
+			return nil, errors.New("missing maximum")
+		}
+		var c Capacity
+		var err error
+		if c.Window, err = time.ParseDuration(window); err != nil {
+			return nil, err
+		}
+		if c.Min, err = strconv.ParseFloat(min, 64); err != nil {
+			return nil, err
+		}
+		if c.Max, err = strconv.ParseFloat(max, 64); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	flow, burst, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, errors.New("unknown format")
+	}
+	var r Rate
+	var err error
+	if r.Flow, err = strconv.ParseFloat(flow, 64); err != nil {
+		return nil, err
+	}
+	if r.Burst, err = strconv.ParseFloat(burst, 64); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func parseBackoff(s string) (Config, error) {
+	kind, raw, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return nil, errors.New("missing factor")
+	}
+	factor, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "constant":
+		return WithConstantBackoff(factor), nil
+	case "linear":
+		return WithLinearBackoff(factor), nil
+	case "power":
+		return WithPowerBackoff(factor), nil
+	case "exponential":
+		return WithExponentialBackoff(factor), nil
+	case "fibonacci":
+		return WithFibonacciBackoff(factor), nil
+	case "decorrelated":
+		return WithDecorrelatedJitterBackoff(factor), nil
+	}
+	return nil, errors.New("unknown kind")
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var errInvalidReply = errors.New("limiter: invalid type returned from eval")
+
+var (
+	// Error prefixes returned by Redis for conditions which are expected to clear.
+	transientErrors = []string{"CLUSTERDOWN", "TRYAGAIN", "LOADING", "MASTERDOWN"}
+
+	// Error fragments returned by Redis when a script or function fails.
+	scriptErrors = []string{"NOSCRIPT", "BUSY", "Error running script", "Error compiling script", "user_script", "user_function", "Function not found"}
+
+	// Error fragments returned by Redis when it cannot parse a request.
+	protocolErrors = []string{"Protocol error", "unknown command", "wrong number of arguments"}
+)
+
+// IsTransient reports whether the error is expected to clear on its own, such
+// as a connection reset or a failover in progress, so the call may be retried.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	if op := (*net.OpError)(nil); errors.As(err, &op) && !op.Timeout() {
+		return true
+	}
+	if failover := (*FailoverError)(nil); errors.As(err, &failover) {
+		return true
+	}
+	msg := err.Error()
+	for _, prefix := range transientErrors {
+		if strings.HasPrefix(msg, prefix+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsScriptError reports whether the error was raised by Redis while loading or
+// running the script, which typically indicates a bug or an incompatible server.
+func IsScriptError(err error) bool {
+	return err != nil && containsAny(err.Error(), scriptErrors)
+}
+
+// IsProtocolError reports whether the error indicates that Redis and the
+// limiter could not understand each other, such as an unexpected reply from
+// the script or a command which the server does not support.
+func IsProtocolError(err error) bool {
+	return errors.Is(err, errInvalidReply) || (err != nil && containsAny(err.Error(), protocolErrors))
+}
+
+func containsAny(msg string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
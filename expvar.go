@@ -0,0 +1,13 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "expvar"
+
+// Publish publishes the statistics of the limiter as an expvar variable with
+// the given name, which must not already be in use, so that they are served
+// alongside any other variables at /debug/vars.
+func (l *Limiter) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return l.Stats() }))
+}
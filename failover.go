@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// FailoverError indicates that a call failed because Redis was unavailable
+// during a failover, such as while a replica is being promoted or a node is
+// still loading its dataset. Such errors are typically resolved shortly.
+type FailoverError struct {
+	Err error
+}
+
+// Error prefixes returned by Redis while a failover is in progress. MOVED and
+// ASK are excluded, as they are normal redirections within a cluster.
+var failoverErrors = []string{"LOADING", "READONLY", "MASTERDOWN", "TRYAGAIN", "CLUSTERDOWN"}
+
+func (e *FailoverError) Error() string {
+	return "limiter: redis failover in progress: " + e.Err.Error()
+}
+
+func (e *FailoverError) Unwrap() error {
+	return e.Err
+}
+
+// WithFailoverRetry retries a call once, after the given delay, if it failed
+// due to a failover. If the retry also fails, a FailoverError is returned.
+func WithFailoverRetry(delay time.Duration) Config {
+	return func(c *config) { c.failover = delay }
+}
+
+func isFailover(err error) bool {
+	msg := err.Error()
+	for _, prefix := range failoverErrors {
+		if strings.HasPrefix(msg, prefix+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) failover(ctx context.Context, exec func() (any, error)) (any, error) {
+	res, err := exec()
+	if err == nil || !isFailover(err) {
+		return res, err
+	}
+
+	if l.failoverDelay > 0 {
+		if err := sleep(ctx, l.failoverDelay); err != nil {
+			return nil, err
+		}
+		if res, err = exec(); err == nil || !isFailover(err) {
+			return res, err
+		}
+	}
+	return nil, &FailoverError{err}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+type fair struct {
+	set    string
+	window time.Duration
+}
+
+// WithFairSharing divides the buckets max-min fairly between the keys which
+// have been tested within the given window, tracked in the given Redis sorted
+// set (and in a second set of their demand, derived from its name), so that
+// the buckets describe a global rate which no single key can consume entirely.
+// Each key's demand is the cost it has tested over the window; keys demanding
+// less than an equal share leave the rest of it to the busier keys, and a key
+// which is alone may use all of it. The backoff is calculated from the
+// undivided buckets. This cannot be used with WithCluster.
+func WithFairSharing(set string, window time.Duration) Config {
+	return func(c *config) { c.fair = fair{set, window} }
+}
+
+func (f fair) args() ([]any, error) {
+	if f.set == "" {
+		return nil, nil
+	}
+	if f.window <= 0 {
+		return nil, errors.New("limiter: fair sharing window must be positive")
+	}
+	return []any{"fair", f.window.Seconds()}, nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+type fallback struct {
+	memory    *Memory
+	instances float64
+}
+
+// WithLocalFallback answers from an in-process approximation of the buckets
+// whenever Redis fails, rather than returning an error, so that rate-limiting
+// degrades gracefully during an outage. Each rate is divided by the given
+// number of instances, which together are expected to share the limit.
+func WithLocalFallback(instances int) Config {
+	return func(c *config) { c.instances = instances }
+}
+
+func newFallback(instances int) (*fallback, error) {
+	if instances == 0 {
+		return nil, nil
+	}
+	if instances < 0 {
+		return nil, errors.New("limiter: fallback instances must be positive")
+	}
+	return &fallback{NewMemory(), float64(instances)}, nil
+}
+
+func (f *fallback) test(ctx context.Context, l *Limiter, key string, s *settings, keys []string, cost float64, opts ...any) (Result, error) {
+	// Only the rates are scaled; any other options are not supported locally.
+	s = s.sharded(int(f.instances))
+	args := []any{cost}
+	for n := 0; n < s.rates/2; n++ {
+		rate := s.rate(n)
+		args = append(args, rate.Flow, rate.Burst)
+	}
+	args = append(args, opts...)
+
+	raw, err := f.memory.Eval(ctx, "", keys, args)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.result(key, s, cost, raw)
+}
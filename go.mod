@@ -1,6 +1,6 @@
 module github.com/plsmphnx/go-redis-bucket
 
-go 1.18
+go 1.21
 
 require (
 	github.com/go-redis/redis/v8 v8.11.5
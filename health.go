@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// HealthState describes whether rate-limiting is operating normally.
+	HealthState int
+
+	// Health describes the current state of a limiter, and its recent calls to
+	// Redis, for readiness probes and dashboards.
+	Health struct {
+		// State is the state of the limiter as of its most recent call.
+		State HealthState
+
+		// Since is when the limiter entered its current state.
+		Since time.Time
+
+		// LastSuccess is when a call to Redis last succeeded.
+		LastSuccess time.Time
+
+		// LastError is the most recent error from Redis, if any, and LastFailure
+		// is when it occurred.
+		LastError   error
+		LastFailure time.Time
+	}
+
+	health struct {
+		state   atomic.Int32
+		success atomic.Int64
+		mu      sync.Mutex
+		since   time.Time
+		err     error
+		failure time.Time
+	}
+)
+
+const (
+	// HealthNormal indicates that the most recent call to Redis succeeded.
+	HealthNormal HealthState = iota
+
+	// HealthRetrying indicates that a call to Redis is being retried after a
+	// transient error.
+	HealthRetrying
+
+	// HealthFailing indicates that the most recent call to Redis failed, and
+	// there was no fallback.
+	HealthFailing
+
+	// HealthFallback indicates that the most recent call to Redis failed, and
+	// was served by the local fallback instead.
+	HealthFallback
+
+	// HealthCircuitOpen indicates that a shard of a ShardedLimiter has failed,
+	// and its keys are being served by another shard until it cools down.
+	HealthCircuitOpen
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthNormal:
+		return "normal"
+	case HealthRetrying:
+		return "retrying"
+	case HealthFailing:
+		return "failing"
+	case HealthFallback:
+		return "fallback"
+	case HealthCircuitOpen:
+		return "circuit-open"
+	}
+	return "unknown"
+}
+
+// Health reports the current state of the limiter.
+func (l *Limiter) Health() Health {
+	return l.health.get()
+}
+
+// Record a successful call; the state is only locked when it changes.
+func (h *health) ok() {
+	h.success.Store(time.Now().UnixNano())
+	if HealthState(h.state.Load()) != HealthNormal {
+		h.set(HealthNormal, nil)
+	}
+}
+
+func (h *health) set(state HealthState, err error) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if HealthState(h.state.Swap(int32(state))) != state {
+		h.since = now
+	}
+	if err != nil {
+		h.err, h.failure = err, now
+	}
+}
+
+func (h *health) get() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := Health{
+		State:       HealthState(h.state.Load()),
+		Since:       h.since,
+		LastError:   h.err,
+		LastFailure: h.failure,
+	}
+	if success := h.success.Load(); success != 0 {
+		res.LastSuccess = time.Unix(0, success)
+	}
+	return res
+}
+
+// Health reports the combined state of the shards: circuit-open if any shard
+// is cooling down, or otherwise the least healthy state of any shard, along
+// with the latest success and failure of any shard.
+func (s *ShardedLimiter) Health() Health {
+	var res Health
+	now := time.Now().UnixNano()
+	for i, sh := range s.shards {
+		h := sh.limiter.Health()
+		if down := atomic.LoadInt64(&sh.down); down > now {
+			h.State, h.Since = HealthCircuitOpen, time.Unix(0, down).Add(-s.cooldown)
+		}
+		if i == 0 || h.State > res.State || (h.State == res.State && h.Since.After(res.Since)) {
+			res.State, res.Since = h.State, h.Since
+		}
+		if h.LastSuccess.After(res.LastSuccess) {
+			res.LastSuccess = h.LastSuccess
+		}
+		if h.LastFailure.After(res.LastFailure) {
+			res.LastError, res.LastFailure = h.LastError, h.LastFailure
+		}
+	}
+	return res
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	stdhash "hash"
+	"sync"
+)
+
+// Keys a pool of HMAC instances with the same secret, as they are not safe for
+// concurrent use.
+type hmacKeys struct{ pool sync.Pool }
+
+// WithHMACKeys replaces each key with its HMAC-SHA256 under the given secret
+// before it is used in Redis, so that identifiers such as user names or
+// addresses never appear in Redis, its persistence files or the audit stream.
+// The prefix is not replaced. Methods which report keys, such as Keys, Export,
+// TopDenied and WatchTransitions, then report the HMAC, and patterns only
+// match the HMAC; WatchInvalidations then only applies to local fallback.
+func WithHMACKeys(secret []byte) Config {
+	return func(c *config) {
+		if len(secret) == 0 {
+			c.err = errors.New("limiter: HMAC secret must not be empty")
+			return
+		}
+		secret := append([]byte(nil), secret...)
+		c.hmac = &hmacKeys{sync.Pool{New: func() any { return hmac.New(sha256.New, secret) }}}
+	}
+}
+
+// The identifier under which the key is stored, which is its HMAC if enabled.
+func (l *Limiter) id(key string) string {
+	if l.hmac == nil {
+		return key
+	}
+	h := l.hmac.pool.Get().(stdhash.Hash)
+	defer l.hmac.pool.Put(h)
+	h.Reset()
+	h.Write([]byte(key))
+	var sum [sha256.Size]byte
+	return base64.RawURLEncoding.EncodeToString(h.Sum(sum[:0]))
+}
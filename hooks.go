@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "time"
+
+// Hooks provides callbacks invoked with the outcome of each call to Test. Any
+// of them may be nil. They are called synchronously, so they should not block.
+type Hooks struct {
+	// OnAllow is called when the action is allowed.
+	OnAllow func(key string, cost float64, res Result)
+
+	// OnDeny is called when the action is denied.
+	OnDeny func(key string, cost float64, res Result)
+
+	// OnError is called when the test fails.
+	OnError func(key string, cost float64, err error)
+
+	// OnBackoff is called whenever a wait is calculated by the backoff, with
+	// the cost denied since the key was last allowed and the resulting wait,
+	// including for calls to Peek and batches.
+	OnBackoff func(key string, deny float64, wait time.Duration)
+
+	// OnSuperfluous is called by New, Reload and WatchBuckets for each bucket
+	// which is ignored because it is strictly larger than another, and so
+	// would never apply; this usually indicates a misconfigured limit.
+	OnSuperfluous func(rate Rate)
+}
+
+// WithHooks invokes the given callbacks with the outcome of each call to Test.
+func WithHooks(hooks Hooks) Config {
+	return func(c *config) { c.hooks = hooks }
+}
+
+func (h *Hooks) call(key string, cost float64, res Result, err error) {
+	switch {
+	case err != nil:
+		if h.OnError != nil {
+			h.OnError(key, cost, err)
+		}
+	case res.Allow:
+		if h.OnAllow != nil {
+			h.OnAllow(key, cost, res)
+		}
+	default:
+		if h.OnDeny != nil {
+			h.OnDeny(key, cost, res)
+		}
+	}
+}
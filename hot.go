@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+)
+
+type hotKeys struct {
+	shards int
+	hot    func(key string) bool
+}
+
+// WithHotKeySharding splits the state of any key for which the given predicate
+// returns true into the given number of shards, each with that fraction of the
+// flow and burst of every bucket, and tests each call against a shard chosen at
+// random. This spreads the load of extremely hot keys across several Redis keys
+// (and, in a cluster, several nodes), while approximately preserving the limit;
+// a single call may be denied while other shards have capacity, and the cost
+// of a call must fit within the burst of a shard. This applies to Test and
+// Peek; shards are records derived from the key, so they are not reported by
+// Keys or State, and are not deleted by ResetAll.
+func WithHotKeySharding(shards int, hot func(key string) bool) Config {
+	return func(c *config) { c.hot = &hotKeys{shards, hot} }
+}
+
+func (h *hotKeys) validate() error {
+	if h != nil && (h.shards < 2 || h.hot == nil) {
+		return errors.New("limiter: hot keys must have a predicate and at least two shards")
+	}
+	return nil
+}
+
+// The prefixed key and settings with which to test the given key, choosing a
+// shard at random if it is hot.
+func (l *Limiter) shard(key string) (string, *settings) {
+	s := l.current()
+	if l.hot == nil || !l.hot.hot(key) {
+		return l.key(key), s
+	}
+	// Shards are derived within any hash tag, so that they spread across slots.
+	n := rand.Intn(l.hot.shards)
+	return l.wrap(derive(escapeKey(l.id(key)), "shard"+strconv.Itoa(n))), s.sharded(l.hot.shards)
+}
+
+// A copy of the settings with the rates divided between the given number of
+// shards.
+func (s *settings) sharded(shards int) *settings {
+	c := *s
+	c.args = append([]any(nil), s.args...)
+	for i, arg := range c.args[:s.rates] {
+		switch v := arg.(type) {
+		case int64:
+			c.args[i] = max(1, v/int64(shards))
+		case float64:
+			c.args[i] = v / float64(shards)
+		}
+	}
+	c.wire = encode(c.args)
+	return &c
+}
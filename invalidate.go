@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// WatchInvalidations discards any state held locally for a key, such as
+// leases, asynchronous views and local fallback buckets, whenever the key is
+// published on the given channel, until the context is done. The channel is
+// intended to be a keyevent notification channel, such as
+// "__keyevent@0__:del" (which requires notify-keyspace-events to include
+// "Eg"), so that resets and manual edits in Redis take effect promptly rather
+// than once the local state expires. Keys without the prefix of the limiter
+// are ignored. This requires a client supporting Subscribe.
+func (l *Limiter) WatchInvalidations(ctx context.Context, channel string) error {
+	sub, ok := as[Subscribe](l.redis)
+	if !ok {
+		return errors.New("limiter: watching requires a client supporting SUBSCRIBE")
+	}
+	messages, err := sub.Subscribe(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for stored := range messages {
+			l.invalidate(stored)
+		}
+	}()
+	return nil
+}
+
+// Discard the local state of the key as stored in Redis, including that of
+// the key it was sharded from, if any.
+func (l *Limiter) invalidate(stored string) {
+	if l.fallback != nil {
+		l.fallback.memory.forget(stored)
+	}
+	key, ok := l.unkey(stored)
+	if !ok && l.hot != nil {
+		key, ok = l.unshard(stored)
+	}
+	if !ok {
+		return
+	}
+	if l.leaser != nil {
+		l.leaser.forget(key)
+	}
+	if l.async != nil {
+		l.async.forget(key)
+	}
+}
+
+// Recover the key from which the given stored shard was derived, reporting
+// false if it is not a shard.
+func (l *Limiter) unshard(stored string) (string, bool) {
+	key, ok := l.unwrap(stored)
+	if !ok {
+		return "", false
+	}
+	key, kind, ok := underive(key)
+	if !ok || !strings.HasPrefix(kind, "shard") {
+		return "", false
+	}
+	return unescapeKey(key)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// IPKey derives a key from the given address, which may include a port or an
+// IPv6 zone, for the network containing it with the given prefix length: v4
+// bits for IPv4 addresses, and v6 bits for IPv6 addresses. For example, 24 and
+// 48 limit each IPv4 /24 and IPv6 /48 network as a whole, so that a client
+// cannot evade its limit by moving between the many addresses it is typically
+// assigned; 32 and 128 limit each address exactly. IPv4 addresses mapped into
+// IPv6 are treated as IPv4. The key is the network in CIDR notation, or the
+// address alone if the prefix covers all of it.
+func IPKey(addr string, v4 int, v6 int) (string, error) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "", errors.New("limiter: invalid IP address: " + addr)
+	}
+	ip = ip.WithZone("").Unmap()
+
+	bits := v6
+	if ip.Is4() {
+		bits = v4
+	}
+	if bits == ip.BitLen() {
+		return ip.String(), nil
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return "", errors.New("limiter: invalid IP prefix length")
+	}
+	return prefix.String(), nil
+}
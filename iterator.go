@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"sync"
+)
+
+// Iterator provides the state of keys one at a time, scanning incrementally so
+// that large key spaces need not be held in memory. It is not safe for
+// concurrent use, and must be closed if not iterated to completion.
+type Iterator struct {
+	limiter *Limiter
+	pattern string
+
+	start   sync.Once
+	cancel  context.CancelFunc
+	batches chan []scanned
+	keys    []scanned
+	err     error
+}
+
+// Iterate returns an iterator over the state of every key matching the given
+// glob pattern, as passed to Test (without any prefix). This requires a client
+// supporting Scan.
+func (l *Limiter) Iterate(pattern string) *Iterator {
+	return &Iterator{limiter: l, pattern: pattern, batches: make(chan []scanned)}
+}
+
+// Next returns the state of the next key, or false once every key has been
+// returned. Keys may be returned more than once if they change while scanning.
+func (it *Iterator) Next(ctx context.Context) (KeyState, bool, error) {
+	it.start.Do(it.run)
+	for {
+		for len(it.keys) > 0 {
+			key := it.keys[0]
+			it.keys = it.keys[1:]
+			state, ok, err := it.limiter.inspect(ctx, key.key, key.stored)
+			if err != nil || ok {
+				return state, ok, err
+			}
+		}
+
+		select {
+		case keys, ok := <-it.batches:
+			if !ok {
+				return KeyState{}, false, it.err
+			}
+			it.keys = keys
+		case <-ctx.Done():
+			return KeyState{}, false, ctx.Err()
+		}
+	}
+}
+
+// Close stops scanning; subsequent calls to Next report no further keys.
+func (it *Iterator) Close() {
+	it.start.Do(func() { close(it.batches) })
+	if it.cancel != nil {
+		it.cancel()
+	}
+}
+
+// Scan in the background, handing over one batch at a time as it is needed.
+func (it *Iterator) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	it.cancel = cancel
+	go func() {
+		defer close(it.batches)
+		err := it.limiter.scan(ctx, it.pattern, func(keys []scanned) error {
+			select {
+			case it.batches <- keys:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if ctx.Err() == nil {
+			it.err = err
+		}
+	}()
+}
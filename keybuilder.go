@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// KeyBuilder builds keys from several parts, such as a tenant, user and
+// endpoint, so that multi-dimensional limits use a consistent format. Parts are
+// separated by colons, and any colons, percent signs, braces (which would
+// affect cluster slots) and glob characters (which would affect patterns)
+// within them are percent-encoded. A builder may be reused after Reset without
+// allocating again, other than for the key itself. The zero value imposes no
+// maximum length.
+type KeyBuilder struct {
+	buf []byte
+	max int
+}
+
+// The characters which are percent-encoded within parts.
+const keyEscaped = "%:{}*?[]\\"
+
+// NewKeyBuilder creates a builder for keys of at most the given length in
+// bytes, or unlimited if zero. Longer keys are truncated to end with "#" and a
+// hash of the whole key, so that they remain distinct; the maximum should be
+// well over the 17 bytes that this takes, as the hash is itself truncated for
+// shorter maximums.
+func NewKeyBuilder(max int) *KeyBuilder {
+	return &KeyBuilder{max: max}
+}
+
+// Add appends a part to the key.
+func (b *KeyBuilder) Add(part string) *KeyBuilder {
+	if len(b.buf) > 0 {
+		b.buf = append(b.buf, ':')
+	}
+	for i := 0; i < len(part); i++ {
+		c := part[i]
+		if c < ' ' || strings.IndexByte(keyEscaped, c) >= 0 {
+			b.buf = append(b.buf, '%', "0123456789ABCDEF"[c>>4], "0123456789ABCDEF"[c&15])
+		} else {
+			b.buf = append(b.buf, c)
+		}
+	}
+	return b
+}
+
+// Reset removes all parts, keeping the maximum length.
+func (b *KeyBuilder) Reset() {
+	b.buf = b.buf[:0]
+}
+
+// String returns the key, truncated to the maximum length if necessary.
+func (b *KeyBuilder) String() string {
+	if b.max <= 0 || len(b.buf) <= b.max {
+		return string(b.buf)
+	}
+	// The hash is 16 hexadecimal digits, replacing the end of the key.
+	sum := strconv.FormatUint(hash(string(b.buf)), 16)
+	keep := max(0, b.max-17)
+	out := make([]byte, 0, keep+17)
+	out = append(out, b.buf[:keep]...)
+	out = append(out, '#')
+	for i := len(sum); i < 16; i++ {
+		out = append(out, '0')
+	}
+	out = append(out, sum...)
+	return string(out[:b.max])
+}
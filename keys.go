@@ -0,0 +1,211 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+type (
+	// Scan represents a Redis client supporting SCAN, calling fn with each
+	// batch of keys matching the pattern, across every node of a cluster.
+	Scan interface {
+		Scan(ctx context.Context, match string, fn func(keys []string) error) error
+	}
+
+	// KeyState describes the current state of a single rate-limited key.
+	KeyState struct {
+		// Key is the key as passed to Test, without any prefix.
+		Key string
+
+		// Levels are the current levels of each bucket, in order of slowest to
+		// fastest flow.
+		Levels []float64
+
+		// Free is the remaining capacity before calls will be rejected.
+		Free float64
+
+		// Penalty is the time remaining in the penalty box, if any.
+		Penalty time.Duration
+
+		// TTL is the time until the state of the key expires.
+		TTL time.Duration
+
+		// Denied is the cost of calls denied since the key was last allowed.
+		Denied float64
+
+		// Strikes is the number of consecutive denials counted towards the
+		// penalty box.
+		Strikes int
+
+		// Age is the time since the key was first seen, for the warm-up.
+		Age time.Duration
+	}
+
+	// A key found by scanning, as reported and as stored in Redis; the stored
+	// key is used as-is, as the reported key may already be an HMAC.
+	scanned struct {
+		key    string
+		stored string
+	}
+)
+
+// The state is read without modification; keys which do not hold bucket state
+// (such as deduplication and audit keys) produce an empty reply. The state is
+// converted from ticks and the scale of the integer precision, if any.
+const keysInspect = `
+local now = tonumber(ARGV[1])
+if now == 0 then
+  local time = redis.call('time')
+  now = tonumber(time[1]) + tonumber(time[2]) / 1e6
+end
+local tick, scale = tonumber(ARGV[2]), tonumber(ARGV[3])
+now = now * tick
+local value = redis.pcall('get', KEYS[1])
+if type(value) ~= 'string' then
+  return {}
+end
+local ok, last, deny, levels, strikes, penalty, born = pcall(cmsgpack.unpack, value)
+if not ok or type(levels) ~= 'table' then
+  return {}
+end
+local reply = {tostring((now - last) / tick), tostring(math.max(0, (penalty or 0) - now) / tick),
+  redis.call('pttl', KEYS[1]), tostring(deny / scale), strikes or 0, tostring((now - (born or 0)) / tick)}
+for n, level in ipairs(levels) do
+  reply[n + 6] = tostring(level / scale)
+end
+return reply`
+
+// Keys returns the current state of every key held by the limiter, found by
+// scanning for keys with the configured prefix, so that operators can see who
+// is near or over their limits. The levels are drained according to the
+// current buckets. This requires a client supporting Scan, and is intended for
+// administration rather than for use on every request.
+func (l *Limiter) Keys(ctx context.Context) ([]KeyState, error) {
+	return l.states(ctx, "*")
+}
+
+func (l *Limiter) states(ctx context.Context, pattern string) ([]KeyState, error) {
+	var states []KeyState
+	err := l.scan(ctx, pattern, func(keys []scanned) error {
+		for _, key := range keys {
+			state, ok, err := l.inspect(ctx, key.key, key.stored)
+			if err != nil {
+				return err
+			}
+			if ok {
+				states = append(states, state)
+			}
+		}
+		return nil
+	})
+	return states, err
+}
+
+// State returns the current state of the given key, as with Keys. A key with no
+// stored state is reported with empty buckets.
+func (l *Limiter) State(ctx context.Context, key string) (KeyState, error) {
+	state, ok, err := l.inspect(ctx, key, l.key(key))
+	if err != nil || ok {
+		return state, err
+	}
+	s := l.current()
+	state = KeyState{Key: key, Levels: make([]float64, s.rates/2), Free: math.Inf(1)}
+	for n := range state.Levels {
+		state.Free = math.Min(state.Free, s.rate(n).Burst)
+	}
+	return state, nil
+}
+
+// Scan for prefixed keys matching the given pattern, reporting them unprefixed.
+func (l *Limiter) scan(ctx context.Context, pattern string, fn func(keys []scanned) error) error {
+	scanner, ok := as[Scan](l.redis)
+	if !ok {
+		return errors.New("limiter: scanning requires a client supporting SCAN")
+	}
+
+	match := escapeGlob(l.prefix) + pattern
+	if l.tags {
+		match = escapeGlob(l.prefix) + "{" + pattern + "}"
+	}
+	return scanner.Scan(ctx, match, func(keys []string) error {
+		stripped := make([]scanned, 0, len(keys))
+		for _, stored := range keys {
+			if key, ok := l.unkey(stored); ok {
+				stripped = append(stripped, scanned{key, stored})
+			}
+		}
+		return fn(stripped)
+	})
+}
+
+func (l *Limiter) inspect(ctx context.Context, key string, stored string) (KeyState, bool, error) {
+	now := 0.0
+	if l.clock != nil {
+		now = float64(l.clock.Now().UnixNano()) / 1e9
+	}
+	tick, scale := l.settings.Load().units()
+	raw, err := l.redis.Eval(ctx, keysInspect, []string{stored}, []any{now, tick, scale})
+	if err != nil {
+		return KeyState{}, false, err
+	}
+	res, ok := raw.([]any)
+	if !ok || (len(res) != 0 && len(res) < 6) {
+		return KeyState{}, false, errInvalidReply
+	}
+	if len(res) == 0 {
+		return KeyState{}, false, nil
+	}
+
+	elapsed, ok1 := parseFloat(res[0])
+	penalty, ok2 := parseFloat(res[1])
+	ttl, ok3 := res[2].(int64)
+	denied, ok4 := parseFloat(res[3])
+	strikes, ok5 := res[4].(int64)
+	age, ok6 := parseFloat(res[5])
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+		return KeyState{}, false, errInvalidReply
+	}
+
+	s := l.current()
+	state := KeyState{
+		Key:     key,
+		Free:    math.Inf(1),
+		Penalty: seconds(penalty),
+		TTL:     time.Duration(ttl) * time.Millisecond,
+		Denied:  denied,
+		Strikes: int(strikes),
+		Age:     seconds(age),
+	}
+	for n := 0; n < s.rates/2; n++ {
+		rate := s.rate(n)
+		level := 0.0
+		if n+6 < len(res) {
+			stored, ok := parseFloat(res[n+6])
+			if !ok {
+				return KeyState{}, false, errInvalidReply
+			}
+			level = math.Max(0, stored-elapsed*rate.Flow)
+		}
+		state.Levels = append(state.Levels, level)
+		state.Free = math.Min(state.Free, rate.Burst-level)
+	}
+	return state, true, nil
+}
+
+// Escape any glob characters, so that the prefix is matched literally.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\*?[]`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
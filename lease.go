@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// Serves tests locally from capacity leased in bulk from Redis.
+	leaser struct {
+		size  float64
+		ttl   time.Duration
+		mu    sync.Mutex
+		keys  map[string]*lease
+		sweep time.Time
+	}
+
+	lease struct {
+		free   float64
+		expire time.Time
+	}
+)
+
+// WithLeasing consumes capacity from Redis in leases of the given size, which
+// are then used to serve tests locally until exhausted or until the given
+// duration has passed. This greatly reduces the number of calls to Redis for
+// frequently tested keys, at the cost of any unused capacity in an expired
+// lease being lost. If a full lease is denied, the cost alone is tested
+// instead. Tests with a cost of zero are never leased, so that they peek at the
+// current state in Redis. This applies only to Test.
+func WithLeasing(size float64, ttl time.Duration) Config {
+	return func(c *config) { c.lease = &leaser{size: size, ttl: ttl, keys: map[string]*lease{}} }
+}
+
+func (l *leaser) validate() error {
+	if l != nil && (l.size <= 0 || l.ttl <= 0) {
+		return errors.New("limiter: lease parameters must be positive")
+	}
+	return nil
+}
+
+func (l *leaser) do(key string, cost float64, test func(float64) (Result, error)) (Result, error) {
+	if cost == 0 || cost > l.size {
+		return test(cost)
+	}
+
+	now := time.Now()
+	if res, ok := l.take(key, cost, now); ok {
+		return res, nil
+	}
+
+	res, err := test(l.size)
+	if err != nil || !res.Allow {
+		return test(cost)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys[key] = &lease{free: l.size - cost, expire: now.Add(l.ttl)}
+	return res, nil
+}
+
+func (l *leaser) take(key string, cost float64, now time.Time) (Result, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Expired leases are removed periodically, rather than on every call.
+	if now.Sub(l.sweep) >= l.ttl {
+		l.sweep = now
+		for k, ls := range l.keys {
+			if now.After(ls.expire) {
+				delete(l.keys, k)
+			}
+		}
+	}
+
+	if ls, ok := l.keys[key]; ok && now.Before(ls.expire) && ls.free >= cost {
+		ls.free -= cost
+		return Result{Allow: true, Free: ls.free}, true
+	}
+	return Result{}, false
+}
+
+func (l *leaser) forget(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
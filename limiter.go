@@ -6,8 +6,10 @@ package limiter
 import (
 	"context"
 	"errors"
+	"math"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,17 +18,99 @@ type (
 	Config func(*config)
 
 	config struct {
-		rates   []Rate
-		prefix  string
-		backoff func(float64) float64
+		rates    []Rate
+		prefix   string
+		backoff  func(float64) float64
+		backoffs map[Rate]func(float64) float64
+		exact    bool
+		integer  bool
+		jitter   float64
+		maxWait  time.Duration
+		penalty  penalty
+		warmup   warmup
+		dedup    dedup
+		audit    audit
+		weights  weights
+		banning  banning
+		rollover rollover
+		quota    quota
+		meter    meter
+		fair     fair
+		notify   notify
+		replica  replica
+		hmac     *hmacKeys
+		schedule schedule
+		bypass   func(string) bool
+
+		functions bool
+		noEvalSha bool
+		preload   bool
+		tags      bool
+		cluster   bool
+		failover  time.Duration
+		startup   time.Duration
+		instances int
+		retry     retry
+		timeout   time.Duration
+		collapse  bool
+		lease     *leaser
+		hot       *hotKeys
+		region    *region
+		async     bool
+		metrics   Metrics
+		tracer    Tracer
+		hooks     Hooks
+		logger    *logger
+		top       *top
+		clock     Clock
+
+		err error
+	}
+
+	// option provides the script arguments for an optional feature.
+	option interface {
+		args() ([]any, error)
 	}
 
 	// Limiter provides a single rate-limiter instance.
 	Limiter struct {
-		args    []any
-		redis   Eval
-		prefix  string
-		backoff func(float64) float64
+		settings atomic.Pointer[settings]
+		redis    Eval
+		prefix   string
+		dedup    dedup
+		audit    string
+		weights  weights
+		fair     string
+		banning  bool
+		quota    Period
+		meter    Period
+		replica  replica
+		hmac     *hmacKeys
+		bypass   func(string) bool
+
+		functions functions
+		noEvalSha bool
+		tags      bool
+		cluster   bool
+
+		failoverDelay time.Duration
+		fallback      *fallback
+		retries       retry
+		timeout       time.Duration
+		collapser     *collapser
+		leaser        *leaser
+		hot           *hotKeys
+		region        *region
+		async         *async
+		nodes         *nodes
+		metrics       Metrics
+		tracer        Tracer
+		hooks         Hooks
+		logger        *logger
+		stats         stats
+		health        health
+		top           *top
+		clock         Clock
 	}
 
 	// Result provides the result of a rate-limiting test.
@@ -39,6 +123,27 @@ type (
 
 		// Wait indicates how long the caller should wait before trying again.
 		Wait time.Duration
+
+		// Reset indicates how long until every bucket has fully drained.
+		Reset time.Duration
+
+		// RetryAt indicates the earliest time at which the same cost would be
+		// allowed, based on the bucket levels alone. It is zero when allowed.
+		RetryAt time.Time
+
+		// Bucket indicates the most restrictive bucket, numbered from 1 in order
+		// of slowest to fastest flow. It is zero when the key is in the penalty
+		// box, banned or over its quota, or when the result was determined
+		// locally.
+		Bucket int
+	}
+
+	reply struct {
+		allow bool
+		value float64
+		index int64
+		drain float64
+		fit   float64
 	}
 )
 
@@ -47,86 +152,435 @@ func WithPrefix(prefix string) Config {
 	return func(c *config) { c.prefix = prefix }
 }
 
+// WithTimeout executes each call to Redis under a context with the given
+// timeout, independently of any deadline of the context passed by the caller.
+func WithTimeout(timeout time.Duration) Config {
+	return func(c *config) { c.timeout = timeout }
+}
+
 // New creates a new rate-limiter instance.
 func New(redis Eval, bucket Bucket, configs ...Config) (*Limiter, error) {
 	if redis == nil {
 		return nil, errors.New("limiter: must have a redis client")
 	}
 
+	c := newConfig(bucket, configs)
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	settings, err := c.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	functions := functions{enabled: c.functions}
+	if err := functions.validate(redis); err != nil {
+		return nil, err
+	}
+
+	fallback, err := newFallback(c.instances)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Limiter{
+		redis:     redis,
+		prefix:    c.prefix,
+		dedup:     c.dedup,
+		audit:     c.audit.stream,
+		weights:   c.weights,
+		fair:      c.fair.set,
+		banning:   c.banning != (banning{}),
+		quota:     c.quota.period,
+		meter:     c.meter.period,
+		replica:   c.replica,
+		hmac:      c.hmac,
+		bypass:    c.bypass,
+		functions: functions,
+		noEvalSha: c.noEvalSha,
+		tags:      c.tags,
+		cluster:   c.cluster,
+
+		failoverDelay: c.failover,
+		fallback:      fallback,
+		retries:       c.retry,
+		timeout:       c.timeout,
+		leaser:        c.lease,
+		hot:           c.hot,
+		region:        c.region,
+		nodes:         newNodes(redis),
+		metrics:       c.metrics,
+		tracer:        c.tracer,
+		hooks:         c.hooks,
+		logger:        c.logger,
+		top:           c.top,
+		clock:         c.clock,
+	}
+	l.settings.Store(settings)
+	l.warn(c)
+	if c.collapse {
+		l.collapser = newCollapser()
+	}
+	if c.async {
+		l.async = newAsync()
+	}
+	if c.top != nil {
+		l.top.slots = make(chan struct{}, asyncSlots)
+	}
+	if c.preload {
+		if err := l.Preload(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	if c.startup > 0 {
+		if err := l.startupCheck(c.startup); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func newConfig(bucket Bucket, configs []Config) *config {
 	c := &config{}
 	WithLinearBackoff(2)(c)
 	WithAdditionalBucket(bucket)(c)
 	for _, cfg := range configs {
 		cfg(c)
 	}
+	return c
+}
 
-	// Sort rates by the slowest to fastest flow for consistency, or by burst
-	// if flow is the same (to make them easier to filter out later).
-	sort.Slice(c.rates, func(i int, j int) bool {
-		if c.rates[i].Flow != c.rates[j].Flow {
-			return c.rates[i].Flow < c.rates[j].Flow
-		}
-		return c.rates[i].Burst < c.rates[j].Burst
-	})
+// Test whether the given action should be allowed according to the rate limits.
+// A call with no cost only reads the state of the key, and is always allowed;
+// the result reports the remaining capacity, or the wait until the key has
+// any capacity. A negative cost, or one exceeding the burst of any bucket,
+// fails with a CostError.
+func (l *Limiter) Test(ctx context.Context, key string, cost float64) (res Result, err error) {
+	if l.tracer != nil {
+		var end func(Result, error)
+		ctx, end = l.tracer.Start(ctx, key, cost)
+		defer func() { end(res, err) }()
+	}
+	defer func() { l.record(ctx, key, cost, res, err) }()
 
-	// Turn the rate parameters into appropriate arguments for the Lua script.
-	args := []any{c.rates[0].Flow, c.rates[0].Burst}
-	for _, r := range c.rates[1:] {
-		// Any limit that is strictly larger than another is superfluous,
-		// as the smaller limit will always be more restrictive.
-		if r.Burst < args[len(args)-1].(float64) {
-			args = append(args, r.Flow, r.Burst)
+	if l.bypassed(key) {
+		return unlimited(), nil
+	}
+	if l.async != nil {
+		test := func(ctx context.Context, cost float64) (Result, error) {
+			k, s := l.shard(key)
+			return l.test(ctx, key, s, []string{k}, cost, false)
 		}
+		peek := func(ctx context.Context, cost float64) (Result, error) {
+			return l.Peek(ctx, key, cost)
+		}
+		flow := func(bucket int) float64 {
+			if _, s := l.shard(key); bucket > 0 && bucket <= s.rates/2 {
+				return s.rate(bucket - 1).Flow
+			}
+			return 0
+		}
+		return l.async.do(key, cost, test, peek, flow), nil
 	}
 
-	for _, arg := range args {
-		if arg.(float64) <= 0 {
-			return nil, errors.New("limiter: rate parameters must be positive")
+	test := func(cost float64) (Result, error) {
+		k, s := l.shard(key)
+		return l.test(ctx, key, s, []string{k}, cost, false)
+	}
+	if l.collapser != nil {
+		next := test
+		check := func(cost float64) error {
+			_, s := l.shard(key)
+			return s.check(cost)
 		}
+		test = func(cost float64) (Result, error) { return l.collapser.do(key, cost, check, next) }
 	}
+	if l.leaser != nil {
+		return l.leaser.do(key, cost, test)
+	}
+	return test(cost)
+}
 
-	return &Limiter{args, redis, c.prefix, c.backoff}, nil
+// Record the outcome of a test in the stats, hooks, logger and top denied keys.
+func (l *Limiter) record(ctx context.Context, key string, cost float64, res Result, err error) {
+	l.stats.count(res, err)
+	l.hooks.call(key, cost, res, err)
+	if l.logger != nil {
+		l.logger.log(ctx, key, cost, res, err)
+	}
+	if l.top != nil && err == nil && !res.Allow {
+		l.recordDenied(key, cost)
+	}
 }
 
-// Test whether the given action should be allowed according to the rate limits.
-func (l *Limiter) Test(ctx context.Context, key string, cost float64) (Result, error) {
-	keys := []string{l.prefix + key}
+// TestKeys tests whether the given action should be allowed according to the
+// rate limits of every one of the given keys. The cost is consumed from all of
+// the keys if they all allow it, and from none of them otherwise.
+func (l *Limiter) TestKeys(ctx context.Context, keys []string, cost float64) (Result, error) {
+	if len(keys) == 0 {
+		return Result{}, errors.New("limiter: must have at least one key")
+	}
+	if l.bypassed(keys...) {
+		return unlimited(), nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = l.key(key)
+	}
+	if err := l.validateSlots(prefixed); err != nil {
+		return Result{}, err
+	}
+	return l.test(ctx, keys[0], l.current(), prefixed, cost, false)
+}
 
-	args := make([]any, len(l.args)+1)
-	args[0] = cost
-	copy(args[1:], l.args)
+// Peek reports whether the given action would be allowed according to the rate
+// limits, without consuming any capacity. Where the client supports EVAL_RO,
+// this may be served by a replica.
+func (l *Limiter) Peek(ctx context.Context, key string, cost float64) (Result, error) {
+	if l.bypassed(key) {
+		return unlimited(), nil
+	}
+	k, s := l.shard(key)
+	return l.test(ctx, key, s, []string{k}, cost, true, "peek", 1)
+}
 
-	raw, err := exec(ctx, l.redis, keys, args)
+func (l *Limiter) test(ctx context.Context, key string, s *settings, keys []string, cost float64, readOnly bool, opts ...any) (Result, error) {
+	// Settling adjusts a cost already charged, so it may be negative or
+	// exceed the bursts.
+	if len(opts) == 0 || opts[0] != "settle" {
+		if err := s.check(cost); err != nil {
+			return Result{}, err
+		}
+	}
+	if s.unlimited() {
+		return unlimited(), nil
+	}
+	if cost == 0 && !readOnly {
+		// Calls with no cost only read the state, so they are never denied.
+		readOnly, opts = true, append(opts[:len(opts):len(opts)], "peek", 1)
+	}
+	// The deduplication option is always passed first, and its key follows the
+	// limited keys.
+	limited := len(keys)
+	if len(opts) > 0 && opts[0] == "dedup" {
+		limited--
+	}
+	opts, quota := l.quoted(l.clocked(opts))
+
+	if l.region != nil && !readOnly {
+		l.region.record(cost)
+	}
+
+	// The keys and arguments are built in pooled buffers, which are only used
+	// until the reply has been interpreted.
+	buf := buffers.Get().(*buffer)
+	defer buffers.Put(buf)
+	args := s.appendCall(buf.args[:0], cost, opts...)
+	all := l.audited(buf.keys[:0], keys, limited, quota, l.metered())
+	buf.args, buf.keys = args, all
+
+	exec := l.exec
+	if readOnly {
+		exec = l.execRO
+	}
+
+	raw, err := l.failover(ctx, func() (any, error) {
+		return l.retry(ctx, func() (any, error) {
+			ctx, cancel := l.deadline(ctx)
+			defer cancel()
+			if l.metrics == nil {
+				return exec(ctx, all, args)
+			}
+			start := time.Now()
+			res, err := exec(ctx, all, args)
+			l.metrics.Call(time.Since(start), err)
+			return res, err
+		})
+	})
 	if err != nil {
+		// Only failures of Redis, rather than of the caller, affect the health.
+		if ctx.Err() == nil {
+			if l.fallback != nil {
+				l.health.set(HealthFallback, err)
+				return l.fallback.test(ctx, l, key, s, keys, cost, opts...)
+			}
+			l.health.set(HealthFailing, err)
+		}
 		return Result{}, err
 	}
+	l.health.ok()
+	return l.result(key, s, cost, raw)
+}
+
+func (l *Limiter) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.timeout > 0 {
+		return context.WithTimeout(ctx, l.timeout)
+	}
+	return ctx, func() {}
+}
+
+// Sort rates by the slowest to fastest flow for consistency, or by burst if
+// flow is the same, and separate out any which are superfluous. Unlimited rates
+// are neither kept nor superfluous, as they are never enforced.
+func effectiveRates(rates []Rate) (kept []Rate, dropped []Rate) {
+	var limited []Rate
+	for _, r := range rates {
+		if !r.unlimited() {
+			limited = append(limited, r)
+		}
+	}
+	rates = limited
+	sort.Slice(rates, func(i int, j int) bool {
+		if rates[i].Flow != rates[j].Flow {
+			return rates[i].Flow < rates[j].Flow
+		}
+		return rates[i].Burst < rates[j].Burst
+	})
+
+	for i, r := range rates {
+		// Any limit that is strictly larger than another is superfluous,
+		// as the smaller limit will always be more restrictive.
+		if i == 0 || r.Burst < kept[len(kept)-1].Burst {
+			kept = append(kept, r)
+		} else {
+			dropped = append(dropped, r)
+		}
+	}
+	return kept, dropped
+}
+
+// Turn the rate parameters into appropriate arguments for the Lua script.
+func rateArgs(rates []Rate) ([]any, error) {
+	if len(rates) == 0 {
+		return nil, errors.New("limiter: must have at least one bucket")
+	}
 
-	allow, value, index, err := validate(raw)
+	kept, _ := effectiveRates(rates)
+	args := make([]any, 0, 2*len(kept))
+	for _, r := range kept {
+		if r.Flow <= 0 || r.Burst <= 0 {
+			return nil, errors.New("limiter: rate parameters must be positive")
+		}
+		args = append(args, r.Flow, r.Burst)
+	}
+	return args, nil
+}
+
+// Interpret the reply from the script for a single call for the given key, as
+// passed by the caller.
+func (l *Limiter) result(key string, s *settings, cost float64, raw any) (Result, error) {
+	rep, err := validate(raw)
 	if err != nil {
 		return Result{}, err
 	}
 
-	if allow == 1 {
-		return Result{Allow: true, Free: value}, nil
+	res := s.interpret(cost, rep, l.now())
+	if l.metrics != nil {
+		l.metrics.Decision(res, int(rep.index))
+	}
+	if l.hooks.OnBackoff != nil && !res.Allow && rep.index != 0 {
+		l.hooks.OnBackoff(key, rep.value, res.Wait)
+	}
+	return res, nil
+}
+
+func (s *settings) interpret(cost float64, rep reply, now time.Time) Result {
+	if cost == 0 {
+		// Calls with no cost report the current state, and are always allowed.
+		res := Result{Allow: true, Reset: seconds(rep.drain), Bucket: int(rep.index)}
+		switch {
+		case rep.allow:
+			res.Free = rep.value
+		case rep.index == 0:
+			res.Wait = seconds(rep.value)
+		default:
+			res.Wait = seconds(rep.fit)
+		}
+		return res
+	}
+	if rep.allow {
+		return Result{Allow: true, Free: rep.value, Reset: seconds(rep.drain), Bucket: int(rep.index)}
+	}
+
+	res := Result{Allow: false, Reset: seconds(rep.drain), Bucket: int(rep.index)}
+	if rep.fit > 0 {
+		res.RetryAt = now.Add(seconds(rep.fit))
+	}
+	if rep.index == 0 {
+		// The key is in the penalty box; the value is the remaining duration.
+		res.Wait = seconds(rep.value)
 	} else {
-		flow := args[2*index-1].(float64)
-		wait := (cost / flow) * l.backoff(value/cost)
-		return Result{Allow: false, Wait: time.Duration(wait * float64(time.Second))}, nil
+		backoff := s.backoff
+		if n := int(rep.index) - 1; n < len(s.backoffs) {
+			backoff = s.backoffs[n]
+		}
+		wait := rep.fit
+		if backoff != nil {
+			flow := s.rate(int(rep.index) - 1).Flow
+			wait = (cost / flow) * backoff(rep.value/cost)
+		}
+		res.Wait = s.capped(jitter(wait, s.jitter))
+	}
+	return res
+}
+
+// Convert the wait to a duration, capped at the maximum wait if configured, or
+// at the longest duration otherwise. The cap is applied before converting, as
+// backoffs of many denials may be too long for a duration, or infinite.
+func (s *settings) capped(wait float64) time.Duration {
+	limit := time.Duration(math.MaxInt64)
+	if s.maxWait > 0 {
+		limit = s.maxWait
 	}
+	if ns := wait * float64(time.Second); !(ns < float64(limit)) {
+		return limit
+	}
+	return seconds(wait)
 }
 
-func validate(raw any) (allow int64, value float64, index int64, err error) {
-	if res, ok := raw.([]any); ok && len(res) == 3 {
-		if allow, ok = res[0].(int64); ok {
-			if val, ok := res[1].(string); ok {
-				if value, err = strconv.ParseFloat(val, 64); err == nil {
-					if index, ok = res[2].(int64); ok {
+// The reply is {allow, value, index}, optionally followed by {drain, fit}. The
+// script replies with the value in millionths and the times in milliseconds, as
+// integers, so that they need not be parsed; strings in whole units and seconds
+// are also accepted.
+func validate(raw any) (rep reply, err error) {
+	if res, ok := raw.([]any); ok && (len(res) == 3 || len(res) == 5) {
+		if allow, ok := res[0].(int64); ok {
+			if rep.index, ok = res[2].(int64); ok {
+				rep.allow = allow == 1
+				if rep.value, ok = parseValue(res[1], microUnits); ok {
+					if len(res) == 3 {
 						return
 					}
+					if rep.drain, ok = parseValue(res[3], 1000); ok {
+						if rep.fit, ok = parseValue(res[4], 1000); ok {
+							return
+						}
+					}
 				}
 			}
 		}
 	}
-	err = errors.New("limiter: invalid type returned from eval")
+	err = errInvalidReply
 	return
 }
+
+// Parse a value which is either a float string or an integer at the given scale.
+func parseValue(raw any, scale float64) (float64, bool) {
+	if i, ok := raw.(int64); ok {
+		return float64(i) / scale, true
+	}
+	return parseFloat(raw)
+}
+
+func parseFloat(raw any) (float64, bool) {
+	if str, ok := raw.(string); ok {
+		if val, err := strconv.ParseFloat(str, 64); err == nil {
+			return val, true
+		}
+	}
+	return 0, false
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -5,12 +5,22 @@ package limiter_test
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
 	"math"
+	"net/http"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	limiter "github.com/plsmphnx/go-redis-bucket"
+	"github.com/plsmphnx/go-redis-bucket/limitertest"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
@@ -114,7 +124,8 @@ func TestBasicRateMetrics(t *testing.T) {
 		for f.Now() < base+time {
 			res, err := l.Test(ctx, f.Key(), 1)
 			assert.NoError(t, err)
-			assert.Equal(t, res, limiter.Result{Allow: true, Free: free})
+			assert.True(t, res.Allow)
+			assert.Equal(t, res.Free, free)
 
 			f.Sleep(ctx, 1)
 			free += rate.Flow - 1
@@ -159,7 +170,8 @@ func TestMultipleRates(t *testing.T) {
 	for f.Now() < base+timeFast {
 		res, err := l.Test(ctx, f.Key(), 1)
 		assert.NoError(t, err)
-		assert.Equal(t, res, limiter.Result{Allow: true, Free: free})
+		assert.True(t, res.Allow)
+		assert.Equal(t, res.Free, free)
 
 		f.Sleep(ctx, 1)
 		free += fast.Flow - 1
@@ -236,11 +248,12 @@ func TestSubsecondDeltas(t *testing.T) {
 type superfluousRateTester struct{ *testing.T }
 
 func (t superfluousRateTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
-	assert.Equal(t, args, []any{1.0, 0.1, 4.0, 0.2, 2.0, 0.4, 1.0})
+	assert.Equal(t, args, []any{1.0, "0.1", "4", "0.2", "2", "0.4", "1"})
 	return []any{int64(1), "1", int64(1)}, nil
 }
 
 func TestSuperfluousRates(t *testing.T) {
+	var dropped []limiter.Rate
 	l, err := limiter.New(
 		superfluousRateTester{t},
 		limiter.Rate{Burst: 4, Flow: 0.1},                               // 1 - Valid
@@ -248,82 +261,2970 @@ func TestSuperfluousRates(t *testing.T) {
 		limiter.WithAdditionalBucket(limiter.Rate{Burst: 2, Flow: 0.2}), // 3 - Valid
 		limiter.WithAdditionalBucket(limiter.Rate{Burst: 2, Flow: 0.3}), // 4 - Strictly larger than 3
 		limiter.WithAdditionalBucket(limiter.Rate{Burst: 1, Flow: 0.4}), // 5 - Valid
+		limiter.WithHooks(limiter.Hooks{OnSuperfluous: func(rate limiter.Rate) { dropped = append(dropped, rate) }}),
 	)
 	assert.NoError(t, err)
 
+	// The ignored buckets are reported.
+	assert.Equal(t, dropped, []limiter.Rate{{Flow: 0.2, Burst: 3}, {Flow: 0.3, Burst: 2}})
+
 	_, err = l.Test(context.Background(), "key", 1)
 	assert.NoError(t, err)
 }
 
-type errorPassingTester struct{ *testing.T }
+type penaltyBoxTester struct{ *testing.T }
 
-func (t errorPassingTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+func (t penaltyBoxTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Equal(t, args, []any{1.0, "0.1", "4", "penalty", "3", "penalty_ttl", "60"})
+	return []any{int64(0), "45.5", int64(0)}, nil
+}
+
+func TestPenaltyBox(t *testing.T) {
+	// Fails with only one penalty parameter.
+	_, err := limiter.New(penaltyBoxTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPenaltyBox(3, 0))
+	assert.Error(t, err)
+
+	l, err := limiter.New(penaltyBoxTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPenaltyBox(3, time.Minute))
+	assert.NoError(t, err)
+
+	// The remaining penalty is returned directly as the wait.
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: false, Wait: 45500 * time.Millisecond})
+}
+
+type warmupTester struct{ *testing.T }
+
+func (t warmupTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Equal(t, args, []any{1.0, "0.1", "4", "warmup", "0.25", "warmup_ttl", "3600"})
+	return []any{int64(1), "0", int64(1)}, nil
+}
+
+func TestWarmup(t *testing.T) {
+	// Fails with a starting fraction larger than the full burst.
+	_, err := limiter.New(warmupTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithWarmup(2, time.Hour))
+	assert.Error(t, err)
+
+	l, err := limiter.New(warmupTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithWarmup(0.25, time.Hour))
+	assert.NoError(t, err)
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+}
+
+type deduplicationTester struct{ *testing.T }
+
+func (t deduplicationTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Equal(t, keys, []string{"prefix:key", "prefix:key\x00dedup:request"})
+	assert.Equal(t, args, []any{1.0, "0.1", "4", "dedup", 300.0})
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestDeduplication(t *testing.T) {
+	// Fails when deduplication has not been enabled.
+	l, err := limiter.New(deduplicationTester{t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.TestOnce(context.Background(), "key", "request", 1)
+	assert.Error(t, err)
+
+	l, err = limiter.New(deduplicationTester{t}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithPrefix("prefix:"), limiter.WithDeduplication(5*time.Minute))
+	assert.NoError(t, err)
+
+	res, err := l.TestOnce(context.Background(), "key", "request", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Free: 3, Bucket: 1})
+}
+
+type multipleKeysTester struct{ *testing.T }
+
+func (t multipleKeysTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Equal(t, keys, []string{"prefix:user", "prefix:tenant"})
+	assert.Equal(t, args, []any{1.0, "0.1", "4"})
+	return []any{int64(0), "2", int64(1)}, nil
+}
+
+func TestMultipleKeys(t *testing.T) {
+	l, err := limiter.New(multipleKeysTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"))
+	assert.NoError(t, err)
+
+	// Fails with no keys.
+	_, err = l.TestKeys(context.Background(), nil, 1)
+	assert.Error(t, err)
+
+	res, err := l.TestKeys(context.Background(), []string{"user", "tenant"}, 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+}
+
+type retryTimingTester struct{ *testing.T }
+
+func (t retryTimingTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return []any{int64(0), "1", int64(1), "40", "10"}, nil
+}
+
+func TestRetryTiming(t *testing.T) {
+	l, err := limiter.New(retryTimingTester{t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	before := time.Now()
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Reset, 40*time.Second)
+	assert.WithinRange(t, res.RetryAt, before.Add(10*time.Second), time.Now().Add(10*time.Second))
+}
+
+type functionsTester struct {
+	*testing.T
+	version int
+	loaded  bool
+	fcalls  int
+	evals   int
+}
+
+func (t *functionsTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.evals++
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func (t *functionsTester) FCall(ctx context.Context, function string, keys []string, args []any) (any, error) {
+	t.fcalls++
+	switch {
+	case t.version < 7:
+		return nil, errors.New("ERR unknown command 'FCALL', with args beginning with: ")
+	case !t.loaded:
+		return nil, errors.New("ERR Function not found")
+	default:
+		return []any{int64(1), "3", int64(1)}, nil
+	}
+}
+
+func (t *functionsTester) FunctionLoad(ctx context.Context, code string) (string, error) {
+	assert.True(t, strings.HasPrefix(code, "#!lua name=limiter_"))
+	t.loaded = true
+	return "", nil
+}
+
+func TestFunctions(t *testing.T) {
+	// Fails with a client that does not support functions.
+	_, err := limiter.New(superfluousRateTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithFunctions())
+	assert.Error(t, err)
+
+	// Loads the function on first use.
+	f := &functionsTester{T: t, version: 7}
+	l, err := limiter.New(f, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithFunctions())
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+	}
+	assert.True(t, f.loaded)
+	assert.Equal(t, f.fcalls, 3)
+	assert.Equal(t, f.evals, 0)
+
+	// Falls back to EVAL on older servers, without retrying FCALL.
+	f = &functionsTester{T: t, version: 6}
+	l, err = limiter.New(f, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithFunctions())
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, f.fcalls, 1)
+	assert.Equal(t, f.evals, 2)
+}
+
+type readOnlyTester struct{ *testing.T }
+
+func (t readOnlyTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
 	assert.Fail(t, "Should not reach EVAL")
 	return nil, nil
 }
 
-func (t errorPassingTester) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
-	return nil, t
+func (t readOnlyTester) EvalRO(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Equal(t, args, []any{1.0, "0.1", "4", "peek", 1})
+	return []any{int64(1), "3", int64(1)}, nil
 }
 
-func (t errorPassingTester) Error() string {
-	return "unknown error"
+func TestReadOnly(t *testing.T) {
+	l, err := limiter.New(readOnlyTester{t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	res, err := l.Peek(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Free: 3, Bucket: 1})
 }
 
-func TestErrorPassing(t *testing.T) {
-	error := errorPassingTester{t}
-	l, err := limiter.New(error, limiter.Rate{Burst: 4, Flow: 0.1})
+type preloadTester struct {
+	*testing.T
+	loaded bool
+}
+
+func (t *preloadTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Fail(t, "Should not reach EVAL")
+	return nil, nil
+}
+
+func (t *preloadTester) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	assert.True(t, t.loaded)
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func (t *preloadTester) ScriptLoad(ctx context.Context, script string) (string, error) {
+	t.loaded = true
+	return fmt.Sprintf("%x", sha1.Sum([]byte(script))), nil
+}
+
+func TestPreload(t *testing.T) {
+	// Fails with a client that does not support loading scripts.
+	_, err := limiter.New(superfluousRateTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPreload())
+	assert.Error(t, err)
+
+	// The hash of the loaded script must match the embedded hash.
+	p := &preloadTester{T: t}
+	l, err := limiter.New(p, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPreload())
 	assert.NoError(t, err)
 
 	_, err = l.Test(context.Background(), "key", 1)
-	assert.ErrorIs(t, err, error)
+	assert.NoError(t, err)
 }
 
-// Test framework, which also serves as the Redis limiter.Client implementation.
-type framework struct {
-	redis   *redis.Client
-	seconds float64
-	time    string
-	key     string
+type pipelineTester struct {
+	*testing.T
+	pipelines int
+	evals     int
 }
 
-func setup(ctx context.Context, t *testing.T) *framework {
-	f := &framework{
-		redis:   redis.NewClient(&redis.Options{}),
-		seconds: 1,
-		time:    "redis-bucket-test:time:" + t.Name(),
-		key:     "redis-bucket-test:key:" + t.Name(),
+func (t *pipelineTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.evals++
+	return []any{int64(1), "2", int64(1)}, nil
+}
+
+func (t *pipelineTester) EvalShaPipeline(ctx context.Context, sha string, calls []limiter.Call) ([]any, []error) {
+	t.pipelines++
+	assert.Equal(t, calls, []limiter.Call{
+		{Keys: []string{"user"}, Args: []any{1.0, "0.1", "4"}},
+		{Keys: []string{"tenant"}, Args: []any{2.0, "0.5", "8"}},
+		{Keys: []string{"user"}, Args: []any{1.0, "0.1", "4", "peek", 1}},
+	})
+	return []any{[]any{int64(1), "3", int64(1)}, nil, []any{int64(0), "1", int64(1)}},
+		[]error{nil, errors.New("NOSCRIPT No matching script"), nil}
+}
+
+func TestPipeline(t *testing.T) {
+	p := &pipelineTester{T: t}
+	user, err := limiter.New(p, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	tenant, err := limiter.New(p, limiter.Rate{Burst: 8, Flow: 0.5})
+	assert.NoError(t, err)
+
+	var b limiter.Batch
+	assert.Equal(t, b.Test(user, "user", 1), 0)
+	assert.Equal(t, b.Test(tenant, "tenant", 2), 1)
+	assert.Equal(t, b.Peek(user, "user", 1), 2)
+
+	res, err := b.Exec(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, res[0], limiter.Result{Allow: true, Free: 3, Bucket: 1})
+	assert.Equal(t, res[1], limiter.Result{Allow: true, Free: 2, Bucket: 1})
+	assert.False(t, res[2].Allow)
+
+	// Only the test which was missing the script falls back to EVAL.
+	assert.Equal(t, p.pipelines, 1)
+	assert.Equal(t, p.evals, 1)
+
+	// Tests, but not peeks, are counted as with Limiter.Test.
+	assert.Equal(t, user.Stats(), limiter.Stats{Tests: 1, Allows: 1})
+	assert.Equal(t, tenant.Stats(), limiter.Stats{Tests: 1, Allows: 1})
+
+	// Tests missing from a short reply are made individually.
+	s := &shortPipelineTester{}
+	l, err := limiter.New(s, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	b.Test(l, "a", 1)
+	b.Test(l, "b", 1)
+	res, err = b.Exec(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, res, []limiter.Result{{Allow: true, Free: 3, Bucket: 1}, {Allow: true, Free: 2, Bucket: 1}})
+	assert.Equal(t, s.evals, 1)
+}
+
+type shortPipelineTester struct{ evals int }
+
+func (t *shortPipelineTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.evals++
+	return []any{int64(1), "2", int64(1)}, nil
+}
+
+func (t *shortPipelineTester) EvalShaPipeline(ctx context.Context, sha string, calls []limiter.Call) ([]any, []error) {
+	return []any{[]any{int64(1), "3", int64(1)}}, []error{nil}
+}
+
+type clusterTester struct{ *testing.T }
+
+func (t clusterTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	for _, key := range keys {
+		assert.True(t, strings.HasPrefix(key, "prefix:{"))
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestCluster(t *testing.T) {
+	l, err := limiter.New(clusterTester{t}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithPrefix("prefix:"), limiter.WithHashTags(), limiter.WithCluster())
+	assert.NoError(t, err)
+
+	// Hash tags are applied to single keys and any keys derived from them.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+
+	// Keys with the same hash tag are in the same slot.
+	l, err = limiter.New(clusterTester{t}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithPrefix("prefix:"), limiter.WithCluster())
+	assert.NoError(t, err)
+	_, err = l.TestKeys(context.Background(), []string{"{tenant}:user", "{tenant}"}, 1)
+	assert.NoError(t, err)
+
+	// Fails with keys in different slots.
+	_, err = l.TestKeys(context.Background(), []string{"{user}", "{tenant}"}, 1)
+	assert.Error(t, err)
+
+	// Fails with keys shared between every key, which are in other slots.
+	for _, shared := range []limiter.Config{limiter.WithAuditStream("audit", 100), limiter.WithWeights("weights"),
+		limiter.WithFairSharing("active", time.Minute)} {
+		_, err = limiter.New(clusterTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithCluster(), shared)
+		assert.Error(t, err)
 	}
-	f.redis.LPush(ctx, f.time, 0, 1)
-	return f
 }
 
-func (f *framework) Key() string {
-	return f.key
+// The go-redis client can be used directly as a Scripter.
+var _ limiter.Scripter[*redis.Cmd] = (*redis.Client)(nil)
+
+type scripterResult []any
+
+func (r scripterResult) Result() (any, error) {
+	return []any(r), nil
+}
+
+type scripterTester struct{ *testing.T }
+
+func (t scripterTester) Eval(ctx context.Context, script string, keys []string, args ...any) scripterResult {
+	assert.Equal(t, args, []any{1.0, "0.1", "4"})
+	return scripterResult{int64(1), "3", int64(1)}
+}
+
+func (t scripterTester) EvalSha(ctx context.Context, sha string, keys []string, args ...any) scripterResult {
+	return t.Eval(ctx, "", keys, args...)
 }
 
-func (f *framework) Now() float64 {
-	return f.seconds
+func TestScripter(t *testing.T) {
+	l, err := limiter.NewScripter[scripterResult](scripterTester{t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Free: 3, Bucket: 1})
+
+	// Optional interfaces of the client are still used.
+	s := &scanningScripterTester{scripterTester: scripterTester{t}}
+	l, err = limiter.NewScripter[scripterResult](s, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"))
+	assert.NoError(t, err)
+	_, err = l.Keys(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, s.match, "prefix:*")
 }
 
-func (f *framework) Sleep(ctx context.Context, s float64) {
-	f.seconds += s
-	full, part := math.Modf(f.seconds)
-	f.redis.LPush(ctx, f.time, int(math.Floor(part*1e6)), int(full))
+type scanningScripterTester struct {
+	scripterTester
+	match string
 }
 
-func (f *framework) Done(ctx context.Context) {
-	f.redis.Del(ctx, f.key, f.time)
+func (t *scanningScripterTester) Scan(ctx context.Context, match string, fn func(keys []string) error) error {
+	t.match = match
+	return fn(nil)
 }
 
-func (f *framework) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
-	// Patch the script, using a list to perform a mock of the time function.
-	script = strings.Replace(script, "'time'", "'lrange','"+f.time+"',0,1", -1)
-	return f.redis.Eval(ctx, script, keys, args...).Result()
+type shardTester struct {
+	fail  bool
+	calls int
 }
 
-func (f *framework) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
-	// This will always fail since the hash will not match the patched script,
-	// but it validates the fallback path.
-	return f.redis.EvalSha(ctx, sha, keys, args...).Result()
+func (t *shardTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.calls++
+	if t.fail {
+		return nil, errors.New("connection refused")
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestSharded(t *testing.T) {
+	// Fails with no clients.
+	_, err := limiter.NewSharded(nil, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.Error(t, err)
+
+	shards := []*shardTester{{fail: true}, {fail: true}, {fail: true}}
+	l, err := limiter.NewSharded([]limiter.Eval{shards[0], shards[1], shards[2]}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	// Each failing shard is marked as unhealthy in turn.
+	for i := 0; i < len(shards); i++ {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, l.Healthy(), []bool{false, false, false})
+	for _, shard := range shards {
+		assert.Equal(t, shard.calls, 1)
+	}
+
+	// Keys are consistently assigned to the same shard.
+	shards = []*shardTester{{}, {}, {}}
+	l, err = limiter.NewSharded([]limiter.Eval{shards[0], shards[1], shards[2]}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+	}
+	assert.ElementsMatch(t, []int{shards[0].calls, shards[1].calls, shards[2].calls}, []int{0, 0, 3})
+
+	// Invalid costs do not mark the shard as unhealthy.
+	_, err = l.Test(context.Background(), "key", -1)
+	var cost *limiter.CostError
+	assert.ErrorAs(t, err, &cost)
+	assert.Equal(t, l.Healthy(), []bool{true, true, true})
+}
+
+type failoverTester struct {
+	failures int
+	reply    string
+}
+
+func (t *failoverTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	if t.failures > 0 {
+		t.failures--
+		if t.reply != "" {
+			return nil, errors.New(t.reply)
+		}
+		return nil, errors.New("LOADING Redis is loading the dataset in memory")
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestFailover(t *testing.T) {
+	// Failover errors are surfaced with a distinct type.
+	l, err := limiter.New(&failoverTester{failures: 1}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	var failover *limiter.FailoverError
+	assert.ErrorAs(t, err, &failover)
+
+	// A single failure is retried.
+	l, err = limiter.New(&failoverTester{failures: 1}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithFailoverRetry(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+
+	// Repeated failures are not.
+	l, err = limiter.New(&failoverTester{failures: 2}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithFailoverRetry(time.Millisecond))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.ErrorAs(t, err, &failover)
+
+	// Cluster redirections are not failovers.
+	for _, reply := range []string{"MOVED 3999 127.0.0.1:6381", "ASK 3999 127.0.0.1:6381"} {
+		l, err = limiter.New(&failoverTester{failures: 1, reply: reply}, limiter.Rate{Burst: 4, Flow: 0.1},
+			limiter.WithFailoverRetry(time.Millisecond))
+		assert.NoError(t, err)
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.EqualError(t, err, reply)
+	}
+}
+
+type startupTester struct{ time bool }
+
+func (t startupTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	if !strings.Contains(script, "peek") {
+		if !t.time {
+			return nil, errors.New("ERR This Redis command is not allowed from script")
+		}
+		return int64(2), nil
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestStartupCheck(t *testing.T) {
+	// Fails when the server does not support the script.
+	_, err := limiter.New(startupTester{time: false}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithStartupCheck(time.Second))
+	assert.ErrorContains(t, err, "TIME")
+
+	_, err = limiter.New(startupTester{time: true}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithStartupCheck(time.Second))
+	assert.NoError(t, err)
+}
+
+type fallbackTester struct{}
+
+func (fallbackTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestLocalFallback(t *testing.T) {
+	// Fails with a negative number of instances.
+	_, err := limiter.New(fallbackTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithLocalFallback(-1))
+	assert.Error(t, err)
+
+	l, err := limiter.New(fallbackTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithLocalFallback(2))
+	assert.NoError(t, err)
+
+	// The burst is shared between instances.
+	for _, free := range []float64{1, 0} {
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allow)
+		assert.InDelta(t, res.Free, free, 0.01)
+	}
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+
+	// Other keys are unaffected.
+	res, err = l.Test(context.Background(), "other", 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+}
+
+type retryTester struct {
+	err      error
+	failures int
+	calls    int
+}
+
+func (t *retryTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.calls++
+	if t.calls <= t.failures {
+		return nil, t.err
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestRetry(t *testing.T) {
+	// Fails with a negative number of attempts.
+	_, err := limiter.New(&retryTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithRetry(-1, time.Millisecond))
+	assert.Error(t, err)
+
+	// Transient errors are retried up to the given number of attempts.
+	r := &retryTester{err: fmt.Errorf("read: %w", syscall.ECONNRESET), failures: 2}
+	l, err := limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithRetry(2, time.Millisecond))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, r.calls, 3)
+
+	// Other errors are not.
+	r = &retryTester{err: errors.New("ERR syntax error"), failures: 1}
+	l, err = limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithRetry(2, time.Millisecond))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.Error(t, err)
+	assert.Equal(t, r.calls, 1)
+}
+
+type timeoutTester struct{}
+
+func (timeoutTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeout(t *testing.T) {
+	l, err := limiter.New(timeoutTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithTimeout(time.Millisecond))
+	assert.NoError(t, err)
+
+	// The call is abandoned even though the caller's context has no deadline.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+type collapsingTester struct {
+	started chan struct{}
+	release chan struct{}
+	costs   chan any
+}
+
+func (t collapsingTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.started <- struct{}{}
+	<-t.release
+	t.costs <- args[0]
+	return []any{int64(1), "1", int64(1)}, nil
+}
+
+func TestCollapsing(t *testing.T) {
+	c := collapsingTester{make(chan struct{}, 2), make(chan struct{}), make(chan any, 2)}
+	l, err := limiter.New(c, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithCollapsing())
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	test := func() {
+		defer wg.Done()
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allow)
+	}
+
+	// Tests made while the first is in flight are combined.
+	wg.Add(4)
+	go test()
+	<-c.started
+	for i := 0; i < 3; i++ {
+		go test()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(c.release)
+	wg.Wait()
+
+	assert.Equal(t, <-c.costs, 1.0)
+	assert.Equal(t, <-c.costs, 3.0)
+
+	// Invalid costs fail alone, and flights are split before their summed
+	// cost exceeds the burst.
+	c = collapsingTester{make(chan struct{}, 3), make(chan struct{}), make(chan any, 3)}
+	l, err = limiter.New(c, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithCollapsing())
+	assert.NoError(t, err)
+	test = func() {
+		defer wg.Done()
+		res, err := l.Test(context.Background(), "key", 2)
+		assert.NoError(t, err)
+		assert.True(t, res.Allow)
+	}
+	wg.Add(4)
+	go test()
+	<-c.started
+	for i := 0; i < 3; i++ {
+		go test()
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, err = l.Test(context.Background(), "key", 5)
+	assert.Error(t, err)
+	close(c.release)
+	wg.Wait()
+
+	assert.Equal(t, <-c.costs, 2.0)
+	assert.Equal(t, <-c.costs, 4.0)
+	assert.Equal(t, <-c.costs, 2.0)
+}
+
+type leasingTester struct {
+	*testing.T
+	costs []any
+}
+
+func (t *leasingTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.costs = append(t.costs, args[0])
+	if args[0].(float64) > 3 {
+		return []any{int64(0), "5", int64(1)}, nil
+	}
+	return []any{int64(1), "0", int64(1)}, nil
+}
+
+func TestLeasing(t *testing.T) {
+	// Fails with no lease size.
+	_, err := limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithLeasing(0, time.Second))
+	assert.Error(t, err)
+
+	lt := &leasingTester{T: t}
+	l, err := limiter.New(lt, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithLeasing(2, time.Minute))
+	assert.NoError(t, err)
+
+	// A single lease serves several tests.
+	for i := 0; i < 5; i++ {
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allow)
+	}
+	assert.Equal(t, lt.costs, []any{2.0, 2.0, 2.0})
+
+	// Costs which do not fit in a lease are tested directly.
+	_, err = l.Test(context.Background(), "key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, lt.costs, []any{2.0, 2.0, 2.0, 3.0})
+
+	// Tests with no cost peek without taking a lease.
+	_, err = l.Test(context.Background(), "other", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, lt.costs, []any{2.0, 2.0, 2.0, 3.0, 0.0})
+}
+
+type asyncTester struct{ calls chan []any }
+
+func (t asyncTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.calls <- args
+	return []any{int64(0), "2", int64(1)}, nil
+}
+
+func TestAsync(t *testing.T) {
+	a := asyncTester{make(chan []any)}
+	l, err := limiter.New(a, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAsync())
+	assert.NoError(t, err)
+
+	// Unknown keys are allowed, and charged in the background.
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.Equal(t, <-a.calls, []any{1.0, "0.1", "4"})
+
+	// Once Redis reports a denial, the key is denied locally.
+	assert.Eventually(t, func() bool {
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		if res.Allow {
+			<-a.calls
+			return false
+		}
+		assert.Equal(t, <-a.calls, []any{1.0, "0.1", "4", "peek", 1})
+		return true
+	}, time.Second, time.Millisecond)
+
+	// Costs exceeding the cached capacity wait for the shortfall to drain.
+	l, err = limiter.New(staticTester{[]any{int64(1), "2", int64(1)}}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAsync())
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		res, err := l.Test(context.Background(), "key", 3)
+		assert.NoError(t, err)
+		return !res.Allow && res.Wait == 10*time.Second
+	}, time.Second, time.Millisecond)
+}
+
+type nodesTester struct {
+	*testing.T
+	nodes  map[string]string
+	loaded map[string]bool
+	loads  int
+}
+
+func (t *nodesTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Fail(t, "Should not reach EVAL")
+	return nil, nil
+}
+
+func (t *nodesTester) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	if !t.loaded[t.nodes[keys[0]]] {
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func (t *nodesTester) ScriptLoadKey(ctx context.Context, key string, script string) (string, error) {
+	t.loaded[t.nodes[key]] = true
+	t.loads++
+	return fmt.Sprintf("%x", sha1.Sum([]byte(script))), nil
+}
+
+func (t *nodesTester) NodeAddr(ctx context.Context, key string) (string, error) {
+	return t.nodes[key], nil
+}
+
+func TestNodeReload(t *testing.T) {
+	n := &nodesTester{T: t, nodes: map[string]string{"a": "n1", "b": "n1", "c": "n2"}, loaded: map[string]bool{}}
+	l, err := limiter.New(n, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	// The script is loaded once onto each node missing it, whatever the slot.
+	for _, key := range []string{"a", "b", "c"} {
+		_, err = l.Test(context.Background(), key, 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, n.loads, 2)
+}
+
+type invalidReplyTester struct{}
+
+func (invalidReplyTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return "OK", nil
+}
+
+func TestErrorClassification(t *testing.T) {
+	transient := []error{
+		fmt.Errorf("read: %w", syscall.ECONNRESET),
+		errors.New("CLUSTERDOWN The cluster is down"),
+		&limiter.FailoverError{Err: errors.New("READONLY You can't write against a read only replica.")},
+	}
+	for _, err := range transient {
+		assert.True(t, limiter.IsTransient(err), err)
+		assert.False(t, limiter.IsScriptError(err), err)
+		assert.False(t, limiter.IsProtocolError(err), err)
+	}
+
+	script := []error{
+		errors.New("NOSCRIPT No matching script. Please use EVAL."),
+		errors.New("ERR user_script:1: Script attempted to access nonexistent global variable 'x'"),
+	}
+	for _, err := range script {
+		assert.False(t, limiter.IsTransient(err), err)
+		assert.True(t, limiter.IsScriptError(err), err)
+	}
+
+	// Unexpected replies from the script are protocol errors.
+	l, err := limiter.New(invalidReplyTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.True(t, limiter.IsProtocolError(err))
+	assert.False(t, limiter.IsTransient(nil))
+}
+
+type errorPassingTester struct{ *testing.T }
+
+func (t errorPassingTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Fail(t, "Should not reach EVAL")
+	return nil, nil
+}
+
+func (t errorPassingTester) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return nil, t
+}
+
+func (t errorPassingTester) Error() string {
+	return "unknown error"
+}
+
+func TestErrorPassing(t *testing.T) {
+	error := errorPassingTester{t}
+	l, err := limiter.New(error, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.ErrorIs(t, err, error)
+}
+
+// Test framework, which also serves as the Redis limiter.Client implementation.
+type framework struct {
+	*limitertest.Framework
+	redis *redis.Client
+}
+
+type client struct{ *redis.Client }
+
+func (c client) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return c.Client.Eval(ctx, script, keys, args...).Result()
+}
+
+func setup(ctx context.Context, t *testing.T) *framework {
+	r := redis.NewClient(&redis.Options{})
+	return &framework{limitertest.New(t, client{r}), r}
+}
+
+func (f *framework) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	// This will always fail since the hash will not match the patched script,
+	// but it validates the fallback path.
+	return f.redis.EvalSha(ctx, sha, keys, args...).Result()
+}
+
+type metricsRecorder struct {
+	buckets   []int
+	allowed   []bool
+	calls     int
+	errors    int
+	fallbacks int
+}
+
+func (m *metricsRecorder) Decision(res limiter.Result, bucket int) {
+	m.buckets = append(m.buckets, bucket)
+	m.allowed = append(m.allowed, res.Allow)
+}
+
+func (m *metricsRecorder) Call(d time.Duration, err error) {
+	m.calls++
+	if err != nil {
+		m.errors++
+	}
+}
+
+func (m *metricsRecorder) Fallback() {
+	m.fallbacks++
+}
+
+type metricsTester struct{ loaded bool }
+
+func (t *metricsTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.loaded = true
+	return []any{int64(1), "3", int64(2)}, nil
+}
+
+func (t *metricsTester) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	if !t.loaded {
+		return nil, errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return []any{int64(0), "2", int64(1)}, nil
+}
+
+func TestMetrics(t *testing.T) {
+	m := &metricsRecorder{}
+	l, err := limiter.New(&metricsTester{}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithAdditionalBucket(limiter.Rate{Burst: 2, Flow: 1}), limiter.WithMetrics(m))
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+	}
+
+	// Each decision is reported with the most restrictive bucket.
+	assert.Equal(t, m.buckets, []int{2, 1})
+	assert.Equal(t, m.allowed, []bool{true, false})
+	assert.Equal(t, m.calls, 2)
+	assert.Equal(t, m.errors, 0)
+	assert.Equal(t, m.fallbacks, 1)
+}
+
+type traceRecorder struct {
+	keys    []string
+	results []limiter.Result
+}
+
+type traceKey struct{}
+
+func (t *traceRecorder) Start(ctx context.Context, key string, cost float64) (context.Context, func(limiter.Result, error)) {
+	t.keys = append(t.keys, key)
+	return context.WithValue(ctx, traceKey{}, key), func(res limiter.Result, err error) {
+		t.results = append(t.results, res)
+	}
+}
+
+type traceTester struct{ *testing.T }
+
+func (t traceTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	assert.Equal(t, ctx.Value(traceKey{}), "key")
+	return []any{int64(0), "2", int64(1), "1", "1"}, nil
+}
+
+func TestTracer(t *testing.T) {
+	tr := &traceRecorder{}
+	l, err := limiter.New(traceTester{t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithTracer(tr))
+	assert.NoError(t, err)
+
+	// The call to Redis is made under the traced context, and the outcome
+	// (including the denying bucket) is reported.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, tr.keys, []string{"key"})
+	assert.Len(t, tr.results, 1)
+	assert.False(t, tr.results[0].Allow)
+	assert.Equal(t, tr.results[0].Bucket, 1)
+}
+
+func TestHooks(t *testing.T) {
+	var allowed, denied, failed []string
+	hooks := limiter.Hooks{
+		OnAllow: func(key string, cost float64, res limiter.Result) { allowed = append(allowed, key) },
+		OnDeny:  func(key string, cost float64, res limiter.Result) { denied = append(denied, key) },
+		OnError: func(key string, cost float64, err error) { failed = append(failed, key) },
+	}
+
+	l, err := limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithHooks(hooks))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "allow", 1)
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "deny", 4)
+	assert.NoError(t, err)
+
+	l, err = limiter.New(invalidReplyTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithHooks(hooks))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "error", 1)
+	assert.Error(t, err)
+
+	assert.Equal(t, allowed, []string{"allow"})
+	assert.Equal(t, denied, []string{"deny"})
+	assert.Equal(t, failed, []string{"error"})
+
+	// Hooks may be omitted.
+	l, err = limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithHooks(limiter.Hooks{}))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+}
+
+func TestLogger(t *testing.T) {
+	var buf strings.Builder
+	log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	// Denials below the handler level are not logged.
+	l, err := limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithLogger(log, slog.LevelDebug))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 4)
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	l, err = limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithLogger(log, slog.LevelWarn))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+	_, err = l.Test(context.Background(), "key", 4)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "level=WARN")
+	assert.Contains(t, buf.String(), "bucket=1")
+	assert.NotContains(t, buf.String(), "key=key")
+
+	// Errors are always logged.
+	buf.Reset()
+	l, err = limiter.New(invalidReplyTester{}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithLogger(log, slog.LevelDebug))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.Error(t, err)
+	assert.Contains(t, buf.String(), "level=ERROR")
+}
+
+func TestStats(t *testing.T) {
+	l, err := limiter.New(&metricsTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, l.Stats(), limiter.Stats{Tests: 3, Allows: 1, Denies: 2, Fallbacks: 1})
+
+	l, err = limiter.New(invalidReplyTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.Error(t, err)
+	assert.Equal(t, l.Stats(), limiter.Stats{Tests: 1, Errors: 1})
+}
+
+type topTester struct {
+	mu      sync.Mutex
+	denied  map[string]float64
+	windows []string
+}
+
+func (t *topTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case strings.Contains(script, "zincrby"):
+		t.denied[args[1].(string)] += args[0].(float64)
+		return int64(1), nil
+	case strings.Contains(script, "zunionstore"):
+		t.windows = keys
+		return []any{"b", "3", "a", "1"}, nil
+	}
+	if args[0].(float64) > 1 {
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestTopDenied(t *testing.T) {
+	// Fails with no windows.
+	_, err := limiter.New(&topTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithTopDenied(time.Minute, 0))
+	assert.Error(t, err)
+
+	// Fails when not enabled.
+	l, err := limiter.New(&topTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.TopDenied(context.Background(), 10)
+	assert.Error(t, err)
+
+	tt := &topTester{denied: map[string]float64{}}
+	l, err = limiter.New(tt, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithTopDenied(time.Minute, 3))
+	assert.NoError(t, err)
+
+	// Only denials are recorded.
+	for _, key := range []string{"a", "b", "b"} {
+		_, err = l.Test(context.Background(), key, 2)
+		assert.NoError(t, err)
+	}
+	_, err = l.Test(context.Background(), "c", 1)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		tt.mu.Lock()
+		defer tt.mu.Unlock()
+		return tt.denied["a"] == 2 && tt.denied["b"] == 4
+	}, time.Second, time.Millisecond)
+	assert.NotContains(t, tt.denied, "c")
+
+	// Every window shares a slot with the temporary union.
+	top, err := l.TopDenied(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, top, []limiter.Offender{{Key: "b", Denied: 3}, {Key: "a", Denied: 1}})
+	assert.Len(t, tt.windows, 4)
+	for _, key := range tt.windows {
+		assert.True(t, strings.HasPrefix(key, "{top-denied}:"))
+	}
+
+	// Windows are chosen according to the clock.
+	l, err = limiter.New(tt, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithTopDenied(time.Minute, 3), limiter.WithClock(fixedClock(time.Unix(600, 0))))
+	assert.NoError(t, err)
+	_, err = l.TopDenied(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, tt.windows, []string{"{top-denied}:10", "{top-denied}:9", "{top-denied}:8", "{top-denied}:union"})
+}
+
+type auditTester struct {
+	*testing.T
+	keys [][]string
+}
+
+func (t *auditTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.keys = append(t.keys, append([]string(nil), keys...))
+	assert.Equal(t, args[3:5], []any{"audit", "1000"})
+	return []any{int64(0), "2", int64(1)}, nil
+}
+
+func TestAuditStream(t *testing.T) {
+	// Fails with no stream length.
+	_, err := limiter.New(&auditTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAuditStream("audit", 0))
+	assert.Error(t, err)
+
+	a := &auditTester{T: t}
+	l, err := limiter.New(a, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithAuditStream("audit", 1000), limiter.WithDeduplication(time.Minute))
+	assert.NoError(t, err)
+
+	// The stream follows every other key.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	_, err = l.TestOnce(context.Background(), "key", "id", 1)
+	assert.NoError(t, err)
+	_, err = l.Peek(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, a.keys, [][]string{{"key", "audit"}, {"key", "key\x00dedup:id", "audit"}, {"key", "audit"}})
+}
+
+func TestPublish(t *testing.T) {
+	l, err := limiter.New(&metricsTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	l.Publish("limiter-test")
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.JSONEq(t, expvar.Get("limiter-test").String(),
+		`{"Tests": 1, "Allows": 1, "Denies": 0, "Errors": 0, "Fallbacks": 1}`)
+}
+
+type paceTester struct {
+	mu    sync.Mutex
+	calls map[string]int
+	costs []any
+}
+
+// Every first test of a key is denied.
+func (t *paceTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls[keys[0]]++
+	t.costs = append(t.costs, args[0])
+	if t.calls[keys[0]] == 1 {
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestWait(t *testing.T) {
+	p := &paceTester{calls: map[string]int{}}
+	l, err := limiter.New(p, limiter.Rate{Burst: 4, Flow: 1000})
+	assert.NoError(t, err)
+
+	res, err := l.Wait(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.Equal(t, p.calls["key"], 2)
+
+	// Waiting stops when the context is done.
+	l, err = limiter.New(p, limiter.Rate{Burst: 4, Flow: 0.001})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = l.Wait(ctx, "other", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPace(t *testing.T) {
+	type msg struct {
+		tenant string
+		size   float64
+	}
+	tenant := func(m msg) string { return m.tenant }
+	size := func(m msg) float64 { return m.size }
+
+	p := &paceTester{calls: map[string]int{}}
+	l, err := limiter.New(p, limiter.Rate{Burst: 4, Flow: 1000})
+	assert.NoError(t, err)
+
+	var processed []msg
+	process := limiter.Pace(l, tenant, size, func(ctx context.Context, m msg) error {
+		processed = append(processed, m)
+		return nil
+	})
+	assert.NoError(t, process(context.Background(), msg{"a", 1}))
+	assert.Equal(t, processed, []msg{{"a", 1}})
+	assert.Equal(t, p.costs, []any{1.0, 1.0})
+
+	// Batches are charged once per tenant.
+	p = &paceTester{calls: map[string]int{}}
+	l, err = limiter.New(p, limiter.Rate{Burst: 4, Flow: 1000})
+	assert.NoError(t, err)
+
+	var batches [][]msg
+	processBatch := limiter.PaceBatch(l, tenant, size, func(ctx context.Context, ms []msg) error {
+		batches = append(batches, ms)
+		return nil
+	})
+	assert.NoError(t, processBatch(context.Background(), []msg{{"a", 1}, {"b", 2}, {"a", 1}}))
+	assert.Equal(t, batches, [][]msg{{{"a", 1}, {"a", 1}}, {{"b", 2}}})
+	assert.Equal(t, p.costs, []any{2.0, 2.0, 2.0, 2.0})
+
+	// Totals exceeding the burst are charged in chunks.
+	p.costs = nil
+	batches = nil
+	assert.NoError(t, processBatch(context.Background(), []msg{{"c", 3}, {"c", 3}}))
+	assert.Equal(t, batches, [][]msg{{{"c", 3}, {"c", 3}}})
+	assert.Equal(t, p.costs, []any{4.0, 4.0, 2.0})
+}
+
+type roundTripper []string
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	*r = append(*r, req.URL.Host)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestTransport(t *testing.T) {
+	p := &paceTester{calls: map[string]int{}}
+	l, err := limiter.New(p, limiter.Rate{Burst: 4, Flow: 1000})
+	assert.NoError(t, err)
+
+	base := &roundTripper{}
+	client := &http.Client{Transport: &limiter.Transport{Limiter: l, Base: base}}
+
+	// Requests are sent once allowed, keyed by host.
+	res, err := client.Get("http://example.com/path")
+	assert.NoError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	assert.Equal(t, []string(*base), []string{"example.com"})
+	assert.Equal(t, p.calls["example.com"], 2)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	// Fails with no buckets.
+	_, _, err := limiter.ConfigFromEnv("LIMITER_TEST")
+	assert.Error(t, err)
+
+	// Fails with an invalid bucket or backoff.
+	t.Setenv("LIMITER_TEST_BUCKETS", "10/1m")
+	_, _, err = limiter.ConfigFromEnv("LIMITER_TEST")
+	assert.Error(t, err)
+	t.Setenv("LIMITER_TEST_BUCKETS", "1:4")
+	t.Setenv("LIMITER_TEST_BACKOFF", "quadratic:2")
+	_, _, err = limiter.ConfigFromEnv("LIMITER_TEST")
+	assert.Error(t, err)
+
+	t.Setenv("LIMITER_TEST_BUCKETS", "0.1:4, 10-20/1m")
+	t.Setenv("LIMITER_TEST_BACKOFF", "constant:3")
+	t.Setenv("LIMITER_TEST_KEY_PREFIX", "env:")
+	bucket, cfg, err := limiter.ConfigFromEnv("LIMITER_TEST")
+	assert.NoError(t, err)
+	assert.Equal(t, bucket, limiter.Rate{Flow: 0.1, Burst: 4})
+
+	e := &envTester{}
+	l, err := limiter.New(e, bucket, cfg)
+	assert.NoError(t, err)
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"env:key"})
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4"})
+
+	// The backoff is constant.
+	assert.Equal(t, res.Wait, 30*time.Second)
+}
+
+type envTester struct {
+	keys []string
+	args []any
+}
+
+func (t *envTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.keys, t.args = keys, args
+	return []any{int64(0), "2", int64(1)}, nil
+}
+
+type watchTester struct {
+	mu       sync.Mutex
+	def      any
+	args     []any
+	messages chan string
+}
+
+func (t *watchTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if strings.HasPrefix(script, "return redis.call('hget'") {
+		return t.def, nil
+	}
+	t.args = args
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func (t *watchTester) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	return t.messages, nil
+}
+
+func (t *watchTester) define(def any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.def = def
+}
+
+func TestWatchBuckets(t *testing.T) {
+	// Fails without a client supporting SUBSCRIBE.
+	l, err := limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	assert.Error(t, l.WatchBuckets(context.Background(), "limits", "api", "limits"))
+
+	w := &watchTester{messages: make(chan string)}
+	l, err = limiter.New(w, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPenaltyBox(3, time.Minute))
+	assert.NoError(t, err)
+
+	// Fails if the definition is missing.
+	assert.Error(t, l.WatchBuckets(context.Background(), "limits", "api", "limits"))
+
+	w.define("1:8, 2:6")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, l.WatchBuckets(ctx, "limits", "api", "limits"))
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, w.args, []any{1.0, "1", "8", "2", "6", "penalty", "3", "penalty_ttl", "60"})
+
+	// The buckets are replaced when notified, keeping other options.
+	w.define("0.5:2")
+	w.messages <- "changed"
+	assert.Eventually(t, func() bool {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.args[1] == "0.5"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, w.args, []any{1.0, "0.5", "2", "penalty", "3", "penalty_ttl", "60"})
+
+	// The buckets replace those of the schedule, and superfluous buckets are
+	// reported.
+	var dropped []limiter.Rate
+	l, err = limiter.New(w, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithSchedule(nil, limiter.Window{End: 24 * time.Hour, Buckets: []limiter.Bucket{limiter.Rate{Burst: 8, Flow: 1}}}),
+		limiter.WithHooks(limiter.Hooks{OnSuperfluous: func(rate limiter.Rate) { dropped = append(dropped, rate) }}))
+	assert.NoError(t, err)
+	w.define("2:4, 3:6")
+	assert.NoError(t, l.WatchBuckets(ctx, "limits", "api", "limits"))
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, w.args, []any{1.0, "2", "4"})
+	assert.Equal(t, dropped, []limiter.Rate{{Burst: 6, Flow: 3}})
+}
+
+func TestWatchInvalidations(t *testing.T) {
+	w := &watchTester{messages: make(chan string)}
+	l, err := limiter.New(w, limiter.Rate{Burst: 2000, Flow: 10}, limiter.WithPrefix("p:"), limiter.WithLeasing(1000, time.Minute))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, l.WatchInvalidations(ctx, "__keyevent@0__:del"))
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	called := func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.args != nil
+	}
+	w.mu.Lock()
+	w.args = nil
+	w.mu.Unlock()
+
+	// The lease serves tests until the key is deleted in Redis.
+	w.messages <- "other:key"
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.False(t, called())
+
+	w.messages <- "p:key"
+	assert.Eventually(t, func() bool {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		return called()
+	}, time.Second, time.Millisecond)
+
+	// Shards invalidate the key they were sharded from, but keys which merely
+	// look like shards do not.
+	w = &watchTester{messages: make(chan string)}
+	l, err = limiter.New(w, limiter.Rate{Burst: 8000, Flow: 40}, limiter.WithPrefix("p:"), limiter.WithLeasing(1000, time.Minute),
+		limiter.WithHotKeySharding(4, func(key string) bool { return key == "hot" }))
+	assert.NoError(t, err)
+	assert.NoError(t, l.WatchInvalidations(ctx, "__keyevent@0__:del"))
+	_, err = l.Test(context.Background(), "hot", 1)
+	assert.NoError(t, err)
+	w.mu.Lock()
+	w.args = nil
+	w.mu.Unlock()
+
+	w.messages <- "p:hot:shard1"
+	_, err = l.Test(context.Background(), "hot", 1)
+	assert.NoError(t, err)
+	assert.False(t, called())
+
+	w.messages <- "p:hot\x00shard1"
+	assert.Eventually(t, func() bool {
+		_, err = l.Test(context.Background(), "hot", 1)
+		assert.NoError(t, err)
+		return called()
+	}, time.Second, time.Millisecond)
+}
+
+func TestNotifications(t *testing.T) {
+	// Fails with a channel which would be taken for a bucket.
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithNotifications("42"))
+	assert.Error(t, err)
+
+	w := &watchTester{messages: make(chan string)}
+	l, err := limiter.New(w, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("p:"), limiter.WithNotifications("limited"))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, w.args, []any{1.0, "0.1", "4", "notify", "limited"})
+
+	// Transitions of keys with the prefix are reported.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan string, 3)
+	assert.NoError(t, l.WatchTransitions(ctx, "limited", func(key string, limited bool) {
+		events <- fmt.Sprint(key, " ", limited)
+	}))
+	w.messages <- "denied:other:key"
+	w.messages <- "denied:p:key"
+	w.messages <- "allowed:p:key"
+	assert.Equal(t, <-events, "key true")
+	assert.Equal(t, <-events, "key false")
+}
+
+func TestReload(t *testing.T) {
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("reload:"))
+	assert.NoError(t, err)
+
+	// Invalid configurations leave the limiter unchanged.
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 0, Flow: 1}))
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAuditStream("audit", 10)))
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4"})
+	assert.Equal(t, res.Wait, 40*time.Second)
+
+	// Buckets, backoff and options are replaced; other settings are kept.
+	assert.NoError(t, l.Reload(limiter.Rate{Burst: 8, Flow: 1},
+		limiter.WithConstantBackoff(1), limiter.WithPenaltyBox(3, time.Minute)))
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"reload:key"})
+	assert.Equal(t, e.args, []any{1.0, "1", "8", "penalty", "3", "penalty_ttl", "60"})
+	assert.Equal(t, res.Wait, time.Second)
+}
+
+func TestRegistry(t *testing.T) {
+	// Fails with no provider or size.
+	_, err := limiter.NewRegistry(nil, 2, 0)
+	assert.Error(t, err)
+	_, err = limiter.NewRegistry(func(string) (*limiter.Limiter, error) { return nil, nil }, 0, 0)
+	assert.Error(t, err)
+
+	var built []string
+	provider := func(tenant string) (*limiter.Limiter, error) {
+		if tenant == "invalid" {
+			return nil, errors.New("unknown tenant")
+		}
+		built = append(built, tenant)
+		return limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	}
+	r, err := limiter.NewRegistry(provider, 2, 0)
+	assert.NoError(t, err)
+
+	// Limiters are cached, evicting the least recently used.
+	for _, tenant := range []string{"a", "b", "a", "c", "a", "b"} {
+		res, err := r.Test(context.Background(), tenant, "key", 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allow)
+	}
+	assert.Equal(t, built, []string{"a", "b", "c", "b"})
+	assert.Equal(t, r.Len(), 2)
+
+	// Errors are returned and not cached.
+	_, err = r.Get("invalid")
+	assert.Error(t, err)
+	assert.Equal(t, r.Len(), 2)
+
+	// Limiters are constructed again once expired.
+	built = nil
+	r, err = limiter.NewRegistry(provider, 2, time.Millisecond)
+	assert.NoError(t, err)
+	_, err = r.Get("a")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = r.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, built, []string{"a", "a"})
+}
+
+func TestRouter(t *testing.T) {
+	def, err := limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	strict, err := limiter.New(&leasingTester{T: t}, limiter.Rate{Burst: 1, Flow: 0.1})
+	assert.NoError(t, err)
+
+	r := limiter.NewRouter(def, map[string]*limiter.Limiter{"strict": strict, "open": nil})
+	assert.Equal(t, r.Limiter("strict"), strict)
+	assert.Equal(t, r.Limiter("other"), def)
+
+	// Unlimited routes are always allowed.
+	res, err := r.Test(context.Background(), "open", "key", 100)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+
+	res, err = r.Test(context.Background(), "other", "key", 4)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+}
+
+func TestProfiles(t *testing.T) {
+	profiles := map[string]limiter.Profile{
+		"free": {Bucket: limiter.Rate{Burst: 2, Flow: 0.1}, Configs: []limiter.Config{limiter.WithPrefix("free:")}},
+		"pro":  {Bucket: limiter.Rate{Burst: 8, Flow: 1}},
+	}
+	lookup := func(ctx context.Context, key string) (string, error) {
+		return strings.Split(key, "/")[0], nil
+	}
+
+	// Fails with an invalid profile.
+	_, err := limiter.NewProfiles(&envTester{}, map[string]limiter.Profile{
+		"invalid": {Bucket: limiter.Rate{Burst: 0, Flow: 1}},
+	}, lookup)
+	assert.Error(t, err)
+
+	e := &envTester{}
+	p, err := limiter.NewProfiles(e, profiles, lookup)
+	assert.NoError(t, err)
+
+	_, err = p.Test(context.Background(), "free/a", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"free:free/a"})
+	assert.Equal(t, e.args, []any{1.0, "0.1", "2"})
+
+	_, err = p.Test(context.Background(), "pro/b", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"pro/b"})
+	assert.Equal(t, e.args, []any{1.0, "1", "8"})
+
+	// Unknown profiles are an error.
+	_, err = p.Test(context.Background(), "enterprise/c", 1)
+	assert.Error(t, err)
+
+	// Profiles may be instantiated individually.
+	l, err := profiles["pro"].New(e, limiter.WithPrefix("single:"))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"single:key"})
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, limiter.Validate(limiter.Capacity{Window: time.Minute, Min: 10, Max: 20},
+		limiter.WithAdditionalBucket(limiter.Rate{Flow: 1, Burst: 5})))
+
+	// Invalid buckets are reported specifically.
+	err := limiter.Validate(limiter.Capacity{Window: -time.Minute, Min: 10, Max: 20})
+	assert.EqualError(t, err, "limiter: capacity window must be positive")
+	err = limiter.Validate(limiter.Capacity{Window: time.Minute, Min: 20, Max: 10})
+	assert.EqualError(t, err, "limiter: capacity maximum must exceed minimum")
+	err = limiter.Validate(limiter.Rate{Flow: 1, Burst: 0})
+	assert.EqualError(t, err, "limiter: rate parameters must be positive")
+
+	// Other configuration is validated.
+	assert.Error(t, limiter.Validate(limiter.Rate{Flow: 1, Burst: 5}, limiter.WithTimeout(-time.Second)))
+	assert.Error(t, limiter.Validate(limiter.Rate{Flow: 1, Burst: 5}, limiter.WithPenaltyBox(-1, time.Minute)))
+
+	// Superfluous buckets are rejected, although New accepts them.
+	superfluous := []limiter.Config{limiter.WithAdditionalBucket(limiter.Rate{Flow: 2, Burst: 10})}
+	err = limiter.Validate(limiter.Rate{Flow: 1, Burst: 5}, superfluous...)
+	assert.EqualError(t, err, "limiter: bucket is superfluous: flow 2, burst 10")
+	_, err = limiter.New(&envTester{}, limiter.Rate{Flow: 1, Burst: 5}, superfluous...)
+	assert.NoError(t, err)
+}
+
+type evalOnlyTester struct {
+	*testing.T
+	evals int
+}
+
+func (t *evalOnlyTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.evals++
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func (t *evalOnlyTester) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	assert.Fail(t, "Should not reach EVALSHA")
+	return nil, nil
+}
+
+func (t *evalOnlyTester) EvalShaPipeline(ctx context.Context, sha string, calls []limiter.Call) ([]any, []error) {
+	assert.Fail(t, "Should not reach EVALSHA")
+	return nil, nil
+}
+
+func TestWithoutEvalSha(t *testing.T) {
+	e := &evalOnlyTester{T: t}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithoutEvalSha())
+	assert.NoError(t, err)
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+
+	var b limiter.Batch
+	b.Test(l, "key", 1)
+	_, err = b.Exec(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, e.evals, 2)
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestClock(t *testing.T) {
+	e := &envTester{}
+	now := time.Unix(1000, 500000000)
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithClock(fixedClock(now)))
+	assert.NoError(t, err)
+
+	// The time is supplied to the script.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "now", 1000.5})
+
+	_, err = l.Peek(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "peek", 1, "now", 1000.5})
+
+	// The time at which to retry is relative to the clock.
+	l, err = limiter.New(staticTester{[]any{int64(0), "2", int64(1), "1", "10"}}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithClock(fixedClock(now)))
+	assert.NoError(t, err)
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.RetryAt, now.Add(10*time.Second))
+}
+
+func TestMemory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithClock(fixedClock(now)), limiter.WithPenaltyBox(2, time.Minute), limiter.WithDeduplication(time.Minute))
+	assert.NoError(t, err)
+
+	res, err := l.Test(context.Background(), "key", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Allow, true)
+	assert.Equal(t, res.Free, 1.0)
+
+	// Peeking does not consume any capacity.
+	res, err = l.Peek(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Allow, true)
+	assert.Equal(t, res.Free, 0.0)
+
+	// Repeated requests are not charged again.
+	res, err = l.TestOnce(context.Background(), "key", "request", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Allow, true)
+	res, err = l.TestOnce(context.Background(), "key", "request", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Allow, true)
+	assert.Equal(t, res.Free, 0.0)
+
+	// Consecutive denials place the key in the penalty box.
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Allow, false)
+	assert.Equal(t, res.Bucket, 1)
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Allow, false)
+	assert.Equal(t, res.Bucket, 0)
+	assert.Equal(t, res.Wait, time.Minute)
+}
+
+func TestMiniredis(t *testing.T) {
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithMiniredis())
+	assert.NoError(t, err)
+
+	// The local time is supplied to the script.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args[3], "now")
+	assert.InDelta(t, e.args[4], float64(time.Now().UnixNano())/1e9, 1)
+
+	// An explicit clock takes precedence.
+	now := time.Unix(1000, 0)
+	l, err = limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithClock(fixedClock(now)), limiter.WithMiniredis())
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "now", 1000.0})
+}
+
+type keysTester struct {
+	*testing.T
+	keys []string
+}
+
+func (t *keysTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	switch keys[0] {
+	case "prefix*:{user}":
+		return []any{"10", "0", int64(30000), "0", int64(0), "100", "3"}, nil
+	case "prefix*:{penalized}":
+		return []any{"0", "45.5", int64(60000), "5", int64(0), "100"}, nil
+	}
+	return []any{}, nil
+}
+
+func (t *keysTester) Scan(ctx context.Context, match string, fn func(keys []string) error) error {
+	assert.Equal(t, match, `prefix\*:{*}`)
+	return fn(t.keys)
+}
+
+func TestKeys(t *testing.T) {
+	// Fails without a client supporting SCAN.
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.Keys(context.Background())
+	assert.Error(t, err)
+
+	k := &keysTester{t, []string{"prefix*:{user}", "prefix*:{penalized}", "prefix*:{user}\x00dedup:request"}}
+	l, err = limiter.New(k, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix*:"), limiter.WithHashTags())
+	assert.NoError(t, err)
+
+	// Levels are drained, and keys without bucket state are skipped.
+	states, err := l.Keys(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, states, []limiter.KeyState{
+		{Key: "user", Levels: []float64{2}, Free: 2, TTL: 30 * time.Second, Age: 100 * time.Second},
+		{Key: "penalized", Levels: []float64{0}, Free: 4, Penalty: 45500 * time.Millisecond, TTL: time.Minute, Denied: 5, Age: 100 * time.Second},
+	})
+}
+
+type resetTester struct {
+	*testing.T
+	keys    []string
+	deleted [][]string
+}
+
+func (t *resetTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.deleted = append(t.deleted, keys)
+	return int64(len(keys)), nil
+}
+
+func (t *resetTester) Scan(ctx context.Context, match string, fn func(keys []string) error) error {
+	assert.Equal(t, match, "prefix:tenant-*")
+	return fn(t.keys)
+}
+
+func TestResetAll(t *testing.T) {
+	// Records derived from keys, such as quota usage, are not reset.
+	r := &resetTester{T: t, keys: []string{"prefix:tenant-1", "prefix:tenant-1\x00quota:2024-02", "prefix:tenant-2"}}
+	l, err := limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"))
+	assert.NoError(t, err)
+
+	// Fails without a pattern.
+	_, err = l.ResetAll(context.Background(), "")
+	assert.Error(t, err)
+
+	n, err := l.ResetAll(context.Background(), "tenant-*")
+	assert.NoError(t, err)
+	assert.Equal(t, n, 2)
+	assert.Equal(t, r.deleted, [][]string{{"prefix:tenant-1", "prefix:tenant-2"}})
+
+	// Keys in a cluster are deleted individually.
+	r.deleted = nil
+	l, err = limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"), limiter.WithCluster())
+	assert.NoError(t, err)
+	n, err = l.ResetAll(context.Background(), "tenant-*")
+	assert.NoError(t, err)
+	assert.Equal(t, n, 2)
+	assert.Equal(t, r.deleted, [][]string{{"prefix:tenant-1"}, {"prefix:tenant-2"}})
+}
+
+func TestState(t *testing.T) {
+	k := &keysTester{T: t}
+	l, err := limiter.New(k, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix*:"), limiter.WithHashTags())
+	assert.NoError(t, err)
+
+	state, err := l.State(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, state, limiter.KeyState{Key: "user", Levels: []float64{2}, Free: 2, TTL: 30 * time.Second, Age: 100 * time.Second})
+
+	// Keys without state have empty buckets.
+	state, err = l.State(context.Background(), "other")
+	assert.NoError(t, err)
+	assert.Equal(t, state, limiter.KeyState{Key: "other", Levels: []float64{0}, Free: 4})
+}
+
+type importTester struct {
+	*testing.T
+	keys [][]string
+	args [][]any
+}
+
+func (t *importTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.keys, t.args = append(t.keys, keys), append(t.args, args)
+	return int64(1), nil
+}
+
+func TestExportImport(t *testing.T) {
+	k := &keysTester{T: t, keys: []string{"prefix*:{user}", "prefix*:{penalized}"}}
+	l, err := limiter.New(k, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix*:"), limiter.WithHashTags())
+	assert.NoError(t, err)
+
+	// Fails without a pattern.
+	_, err = l.Export(context.Background(), "")
+	assert.Error(t, err)
+
+	states, err := l.Export(context.Background(), "*")
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+
+	// The state is restored under the prefix of the destination.
+	i := &importTester{T: t}
+	l, err = limiter.New(i, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("other:"),
+		limiter.WithClock(fixedClock(time.Unix(1000, 0))))
+	assert.NoError(t, err)
+	assert.NoError(t, l.Import(context.Background(), states))
+	assert.Equal(t, i.keys, [][]string{{"other:user"}, {"other:penalized"}})
+	assert.Equal(t, i.args, [][]any{
+		{1000.0, int64(30000), 0.0, 0, 0.0, 100.0, int64(1), int64(1), 2.0},
+		{1000.0, int64(60000), 5.0, 0, 45.5, 100.0, int64(1), int64(1), 0.0},
+	})
+
+	// Fails for keys without a TTL.
+	assert.Error(t, l.Import(context.Background(), []limiter.KeyState{{Key: "user"}}))
+}
+
+func TestIterate(t *testing.T) {
+	k := &keysTester{T: t, keys: []string{"prefix*:{user}", "prefix*:{user}\x00dedup:request", "prefix*:{penalized}"}}
+	l, err := limiter.New(k, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix*:"), limiter.WithHashTags())
+	assert.NoError(t, err)
+
+	it := l.Iterate("*")
+	defer it.Close()
+	var keys []string
+	for {
+		state, ok, err := it.Next(context.Background())
+		assert.NoError(t, err)
+		if !ok {
+			break
+		}
+		keys = append(keys, state.Key)
+	}
+	assert.Equal(t, keys, []string{"user", "penalized"})
+
+	// Fails without a client supporting SCAN.
+	l, err = limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, _, err = l.Iterate("*").Next(context.Background())
+	assert.Error(t, err)
+}
+
+func TestJitter(t *testing.T) {
+	// Fails with a fraction outside of the unit range.
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithJitter(2))
+	assert.Error(t, err)
+
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithJitter(0.5))
+	assert.NoError(t, err)
+
+	// The wait of 40 seconds varies by up to half in either direction.
+	waits := map[time.Duration]bool{}
+	for i := 0; i < 10; i++ {
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, res.Wait, 20*time.Second)
+		assert.LessOrEqual(t, res.Wait, 60*time.Second)
+		waits[res.Wait] = true
+	}
+	assert.Greater(t, len(waits), 1)
+}
+
+func TestMaxWait(t *testing.T) {
+	// Fails with a negative maximum.
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithMaxWait(-time.Second))
+	assert.Error(t, err)
+
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithExponentialBackoff(10), limiter.WithMaxWait(time.Minute))
+	assert.NoError(t, err)
+
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Wait, time.Minute)
+
+	// Backoffs of many denials are capped before they overflow, or are the
+	// longest duration without a maximum.
+	deny := staticTester{[]any{int64(0), "1e6", int64(1), "1", "0"}}
+	l, err = limiter.New(deny, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithExponentialBackoff(2), limiter.WithMaxWait(time.Minute))
+	assert.NoError(t, err)
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Wait, time.Minute)
+
+	l, err = limiter.New(deny, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithExponentialBackoff(2))
+	assert.NoError(t, err)
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Wait, time.Duration(math.MaxInt64))
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithDecorrelatedJitterBackoff(3))
+	assert.NoError(t, err)
+
+	// The wait varies between the base of 10 seconds and three times that for
+	// each of the 2 denials.
+	waits := map[time.Duration]bool{}
+	for i := 0; i < 10; i++ {
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, res.Wait, 10*time.Second)
+		assert.LessOrEqual(t, res.Wait, 60*time.Second)
+		waits[res.Wait] = true
+	}
+	assert.Greater(t, len(waits), 1)
+}
+
+func TestExactBackoff(t *testing.T) {
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithClock(fixedClock(time.Unix(1000, 0))), limiter.WithExactBackoff())
+	assert.NoError(t, err)
+
+	res, err := l.Test(context.Background(), "key", 4)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+
+	// The wait is the time for 2 of the 4 used to drain.
+	res, err = l.Test(context.Background(), "key", 2)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+	assert.Equal(t, res.Wait, 20*time.Second)
+}
+
+func TestFibonacciBackoff(t *testing.T) {
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 1, Flow: 0.1},
+		limiter.WithClock(fixedClock(time.Unix(1000, 0))), limiter.WithFibonacciBackoff(1))
+	assert.NoError(t, err)
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+
+	// Each denial waits for the next number in the sequence.
+	for _, n := range []time.Duration{1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89, 144} {
+		res, err := l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, res.Wait, n*10*time.Second)
+	}
+
+	// Many denials wait for the longest duration, rather than overflowing.
+	l, err = limiter.New(staticTester{[]any{int64(0), "2e8", int64(1), "1", "0"}}, limiter.Rate{Burst: 1, Flow: 0.1},
+		limiter.WithFibonacciBackoff(1))
+	assert.NoError(t, err)
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Wait, time.Duration(math.MaxInt64))
+}
+
+func TestBucketBackoff(t *testing.T) {
+	fast, slow := limiter.Rate{Flow: 1, Burst: 1}, limiter.Rate{Flow: 0.01, Burst: 5}
+
+	// Fails with a configuration which is not a backoff.
+	_, err := limiter.New(limiter.NewMemory(), fast, limiter.WithBucketBackoff(fast, limiter.WithPrefix("prefix")))
+	assert.Error(t, err)
+
+	clock := limitertest.NewClock(time.Unix(1000, 0))
+	l, err := limiter.New(limiter.NewMemory(), fast, limiter.WithAdditionalBucket(slow), limiter.WithClock(clock),
+		limiter.WithBucketBackoff(fast, limiter.WithConstantBackoff(0.5)),
+		limiter.WithBucketBackoff(slow, limiter.WithExactBackoff()))
+	assert.NoError(t, err)
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+
+	// The fast bucket denies the call, using its own backoff.
+	res, err := l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Bucket, 2)
+	assert.Equal(t, res.Wait, 500*time.Millisecond)
+
+	// The slow bucket denies the call, using its own backoff.
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		_, err = l.Test(context.Background(), "other", 1)
+		assert.NoError(t, err)
+	}
+	clock.Advance(time.Second)
+	res, err = l.Test(context.Background(), "other", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Bucket, 1)
+	// Times are replied in milliseconds, rounded up.
+	assert.InDelta(t, res.Wait.Seconds(), 95, 0.002)
+
+	// The backoff applies to the bucket even once its rate has been rounded.
+	minute := limiter.Capacity{Window: time.Minute, Min: 10, Max: 20}
+	l, err = limiter.New(limiter.NewMemory(), minute, limiter.WithClock(clock), limiter.WithIntegerPrecision(),
+		limiter.WithBucketBackoff(minute, limiter.WithConstantBackoff(0.5)))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 10)
+	assert.NoError(t, err)
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+	assert.InDelta(t, res.Wait.Seconds(), 3, 0.001)
+}
+
+func TestBackoffHook(t *testing.T) {
+	type backoff struct {
+		key  string
+		deny float64
+		wait time.Duration
+	}
+	var backoffs []backoff
+	hooks := limiter.Hooks{
+		OnBackoff: func(key string, deny float64, wait time.Duration) {
+			backoffs = append(backoffs, backoff{key, deny, wait})
+		},
+	}
+
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithPrefix("prefix:"), limiter.WithHashTags(), limiter.WithHooks(hooks))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	_, err = l.Peek(context.Background(), "key", 2)
+	assert.NoError(t, err)
+
+	assert.Equal(t, backoffs, []backoff{{"key", 2, 40 * time.Second}, {"key", 2, 40 * time.Second}})
+
+	// The hook is given the key as passed, rather than as stored.
+	backoffs = nil
+	l, err = limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithHMACKeys([]byte("secret")), limiter.WithHooks(hooks))
+	assert.NoError(t, err)
+	_, err = l.Peek(context.Background(), "key", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, backoffs, []backoff{{"key", 2, 40 * time.Second}})
+}
+
+func TestIntegerPrecision(t *testing.T) {
+	// Fails with rates smaller than the precision.
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 1e-7}, limiter.WithIntegerPrecision())
+	assert.Error(t, err)
+
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithIntegerPrecision())
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1.5)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{int64(1500000), "100000", "4000000", "integer", "1"})
+
+	// Fails to change the precision.
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}))
+
+	// Integer replies are scaled.
+	l, err = limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithClock(fixedClock(time.Unix(1000, 0))), limiter.WithIntegerPrecision(), limiter.WithExactBackoff())
+	assert.NoError(t, err)
+
+	res, err := l.Test(context.Background(), "key", 3.5)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Free: 0.5, Reset: 35 * time.Second, Bucket: 1})
+
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+	assert.Equal(t, res.Wait, 5*time.Second)
+}
+
+func TestCostError(t *testing.T) {
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAdditionalBucket(limiter.Rate{Burst: 8, Flow: 0.01}))
+	assert.NoError(t, err)
+
+	// Costs exceeding any burst are rejected without calling Redis.
+	_, err = l.Test(context.Background(), "key", 5)
+	var cost *limiter.CostError
+	assert.ErrorAs(t, err, &cost)
+	assert.Equal(t, cost, &limiter.CostError{Cost: 5, Burst: 4})
+	assert.Equal(t, err.Error(), "limiter: cost 5 exceeds burst 4")
+	assert.Nil(t, e.args)
+
+	var b limiter.Batch
+	b.Test(l, "key", 5)
+	b.Test(l, "key", 4)
+	results, err := b.Exec(context.Background())
+	assert.ErrorAs(t, err, &cost)
+	assert.Equal(t, results[1].Bucket, 1)
+}
+
+func TestZeroCost(t *testing.T) {
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithClock(fixedClock(time.Unix(1000, 0))), limiter.WithPenaltyBox(1, time.Minute))
+	assert.NoError(t, err)
+
+	// Negative costs are rejected.
+	_, err = l.Test(context.Background(), "key", -1)
+	var cost *limiter.CostError
+	assert.ErrorAs(t, err, &cost)
+	assert.Equal(t, err.Error(), "limiter: cost -1 is negative")
+
+	_, err = l.Test(context.Background(), "key", 3)
+	assert.NoError(t, err)
+
+	// The remaining capacity is reported without consuming any.
+	res, err := l.Test(context.Background(), "key", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Free: 1, Reset: 30 * time.Second, Bucket: 1})
+
+	// Calls with no cost are allowed even in the penalty box, reporting the wait.
+	res, err = l.Test(context.Background(), "key", 2)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+	res, err = l.Test(context.Background(), "key", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Wait: time.Minute, Reset: time.Minute})
+}
+
+func TestTimeBackwards(t *testing.T) {
+	clock := limitertest.NewClock(time.Unix(1000, 0))
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithClock(clock))
+	assert.NoError(t, err)
+
+	_, err = l.Test(context.Background(), "key", 4)
+	assert.NoError(t, err)
+
+	// The level is unchanged, rather than increased, when time goes backwards.
+	clock.Advance(-100 * time.Second)
+	res, err := l.Test(context.Background(), "key", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Free, 0.0)
+
+	// The level drains only once time passes the stored time again.
+	clock.Advance(140 * time.Second)
+	res, err = l.Test(context.Background(), "key", 4)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+}
+
+type skewTester struct{ time []any }
+
+func (t skewTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return t.time, nil
+}
+
+func TestSkew(t *testing.T) {
+	l, err := limiter.New(skewTester{[]any{int64(1000), int64(0)}}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithClock(fixedClock(time.Unix(1002, 500000000))))
+	assert.NoError(t, err)
+
+	skew, err := l.Skew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, skew, 2500*time.Millisecond)
+
+	// Skew beyond the threshold is reported.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	skews := make(chan time.Duration, 1)
+	assert.Error(t, l.WatchSkew(ctx, 0, time.Second, nil))
+	assert.NoError(t, l.WatchSkew(ctx, time.Hour, time.Second, func(skew time.Duration) { skews <- skew }))
+	assert.Equal(t, <-skews, 2500*time.Millisecond)
+
+	// Fails with an invalid reply.
+	l, err = limiter.New(skewTester{[]any{"x", "0"}}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.Skew(context.Background())
+	assert.Error(t, err)
+}
+
+func TestWeights(t *testing.T) {
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"),
+		limiter.WithWeights("weights"), limiter.WithAuditStream("audit", 1000), limiter.WithDeduplication(time.Minute))
+	assert.NoError(t, err)
+
+	// The hash follows the deduplication key, and precedes the stream.
+	_, err = l.TestOnce(context.Background(), "key", "id", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"prefix:key", "prefix:key\x00dedup:id", "weights", "audit"})
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "audit", "1000", "weights", "1", "dedup", 60.0})
+
+	// Weights are set against the prefixed key.
+	assert.NoError(t, l.SetWeight(context.Background(), "key", 0.5))
+	assert.Equal(t, e.keys, []string{"weights"})
+	assert.Equal(t, e.args, []any{"prefix:key", 0.5})
+	assert.Error(t, l.SetWeight(context.Background(), "key", -1))
+
+	// The hash cannot be changed by a reload.
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAuditStream("audit", 1000)))
+
+	// Weights must be enabled to be set.
+	l, err = limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	assert.Error(t, l.SetWeight(context.Background(), "key", 2))
+}
+
+func TestComposite(t *testing.T) {
+	var calls []string
+	fixed := func(name string, res limiter.Result) limiter.Tester {
+		return limiter.TesterFunc(func(ctx context.Context, key string, cost float64) (limiter.Result, error) {
+			calls = append(calls, name+":"+key)
+			return res, nil
+		})
+	}
+	wide := fixed("wide", limiter.Result{Allow: true, Free: 10, Reset: time.Minute, Bucket: 1})
+	narrow := fixed("narrow", limiter.Result{Allow: true, Free: 2, Reset: time.Second, Bucket: 2})
+	slow := fixed("slow", limiter.Result{Wait: time.Minute, Bucket: 1})
+	fast := fixed("fast", limiter.Result{Wait: time.Second, Bucket: 2})
+
+	// All merges allowed results, keeping the least capacity and longest reset.
+	res, err := limiter.All(wide, narrow).Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Allow: true, Free: 2, Reset: time.Minute, Bucket: 2})
+
+	// All stops at the first denial.
+	calls = nil
+	res, err = limiter.All(wide, slow, fast).Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Wait: time.Minute, Bucket: 1})
+	assert.Equal(t, calls, []string{"wide:key", "slow:key"})
+
+	// Any stops at the first allowed result.
+	calls = nil
+	res, err = limiter.Any(slow, narrow, wide).Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Free, 2.0)
+	assert.Equal(t, calls, []string{"slow:key", "narrow:key"})
+
+	// Any keeps the denial with the shortest wait.
+	res, err = limiter.Any(slow, fast).Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Wait: time.Second, Bucket: 2})
+
+	// Limiters may be keyed independently, and errors are passed through.
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	ip := limiter.TesterFunc(func(ctx context.Context, key string, cost float64) (limiter.Result, error) {
+		return l.Test(ctx, "ip:"+key, cost)
+	})
+	_, err = limiter.Any(l, ip).Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"ip:key"})
+	_, err = limiter.All(l).Test(context.Background(), "key", 5)
+	assert.Error(t, err)
+	_, err = limiter.Any().Test(context.Background(), "key", 1)
+	assert.Error(t, err)
+}
+
+func TestBypass(t *testing.T) {
+	e := &envTester{}
+	var allowed []string
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithDeduplication(time.Minute),
+		limiter.WithBypass(func(key string) bool { return strings.HasPrefix(key, "internal:") }),
+		limiter.WithHooks(limiter.Hooks{OnAllow: func(key string, cost float64, res limiter.Result) {
+			allowed = append(allowed, key)
+		}}))
+	assert.NoError(t, err)
+
+	// Exempt keys are allowed without calling Redis.
+	unlimited := limiter.Result{Allow: true, Free: math.Inf(1)}
+	res, err := l.Test(context.Background(), "internal:health", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, unlimited)
+	res, err = l.Peek(context.Background(), "internal:health", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, unlimited)
+	res, err = l.TestOnce(context.Background(), "internal:health", "id", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, unlimited)
+	res, err = l.TestKeys(context.Background(), []string{"internal:a", "internal:b"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, unlimited)
+	var b limiter.Batch
+	b.Test(l, "internal:health", 1)
+	results, err := b.Exec(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, results, []limiter.Result{unlimited})
+	assert.Nil(t, e.keys)
+	assert.Equal(t, allowed, []string{"internal:health", "internal:health"})
+
+	// Other keys are limited, including alongside exempt keys.
+	_, err = l.TestKeys(context.Background(), []string{"internal:a", "user"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"internal:a", "user"})
+	res, err = l.Test(context.Background(), "user", 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+}
+
+type banTester struct {
+	keys [][]string
+	ttl  any
+}
+
+func (t *banTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.keys = append(t.keys, append([]string(nil), keys...))
+	switch {
+	case strings.HasPrefix(script, "return redis.call('pttl'"):
+		return t.ttl, nil
+	case strings.HasPrefix(script, "return"):
+		return int64(1), nil
+	}
+	return []any{int64(0), "30", int64(0), "30", "30"}, nil
+}
+
+func TestBanning(t *testing.T) {
+	_, err := limiter.New(&banTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithBanning(1, 0, time.Hour))
+	assert.Error(t, err)
+
+	b := &banTester{ttl: int64(90000)}
+	l, err := limiter.New(b, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"),
+		limiter.WithBanning(0.5, time.Minute, time.Hour), limiter.WithDeduplication(time.Minute),
+		limiter.WithAuditStream("audit", 1000))
+	assert.NoError(t, err)
+
+	// The ban records of the limited keys follow the deduplication key.
+	res, err := l.TestOnce(context.Background(), "key", "id", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, res, limiter.Result{Wait: 30 * time.Second, Reset: 30 * time.Second, RetryAt: res.RetryAt})
+	_, err = l.TestKeys(context.Background(), []string{"a", "b"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, b.keys, [][]string{
+		{"prefix:key", "prefix:key\x00dedup:id", "prefix:key\x00ban", "audit"},
+		{"prefix:a", "prefix:b", "prefix:a\x00ban", "prefix:b\x00ban", "audit"},
+	})
+
+	// Keys may be banned and unbanned explicitly.
+	b.keys = nil
+	assert.NoError(t, l.Ban(context.Background(), "key", time.Minute))
+	banned, wait, err := l.IsBanned(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.True(t, banned)
+	assert.Equal(t, wait, 90*time.Second)
+	assert.NoError(t, l.Unban(context.Background(), "key"))
+	assert.Equal(t, b.keys, [][]string{{"prefix:key\x00ban"}, {"prefix:key\x00ban"}, {"prefix:key\x00ban"}})
+
+	// Keys cannot collide with the ban records of other keys.
+	b.keys = nil
+	_, err = l.Test(context.Background(), "key\x00ban", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, b.keys, [][]string{{"prefix:key\x00\x00ban", "prefix:key\x00\x00ban\x00ban", "audit"}})
+
+	b.ttl = int64(-2)
+	banned, _, err = l.IsBanned(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.False(t, banned)
+	assert.Error(t, l.Ban(context.Background(), "key", 0))
+
+	// Banning cannot be enabled or disabled by a reload.
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAuditStream("audit", 1000)))
+	assert.NoError(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithAuditStream("audit", 1000),
+		limiter.WithBanning(1, time.Minute, time.Hour)))
+
+	// Banning must be enabled to ban keys.
+	l, err = limiter.New(b, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	assert.Error(t, l.Ban(context.Background(), "key", time.Minute))
+	assert.Error(t, l.Unban(context.Background(), "key"))
+	_, _, err = l.IsBanned(context.Background(), "key")
+	assert.Error(t, err)
+}
+
+func TestSchedule(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithSchedule(nil, limiter.Window{Start: 25 * time.Hour, Buckets: []limiter.Bucket{limiter.Rate{Burst: 8, Flow: 1}}}))
+	assert.Error(t, err)
+	_, err = limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithSchedule(nil, limiter.Window{}))
+	assert.Error(t, err)
+
+	nights := limiter.Window{Start: 20 * time.Hour, End: 6 * time.Hour, Buckets: []limiter.Bucket{limiter.Rate{Burst: 8, Flow: 1}}}
+	weekends := limiter.Window{Days: []time.Weekday{time.Saturday, time.Sunday}, End: 24 * time.Hour,
+		Buckets: []limiter.Bucket{limiter.Rate{Burst: 16, Flow: 2}}}
+
+	for _, test := range []struct {
+		time time.Time
+		args []any
+	}{
+		{time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC), []any{1.0, "0.1", "4"}}, // Wednesday noon
+		{time.Date(2024, 1, 3, 21, 0, 0, 0, time.UTC), []any{1.0, "1", "8"}},   // Wednesday night
+		{time.Date(2024, 1, 4, 5, 0, 0, 0, time.UTC), []any{1.0, "1", "8"}},    // Thursday morning
+		{time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC), []any{1.0, "2", "16"}},  // Saturday noon
+	} {
+		e := &envTester{}
+		l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPenaltyBox(3, time.Minute),
+			limiter.WithClock(fixedClock(test.time)), limiter.WithSchedule(time.UTC, nights, weekends))
+		assert.NoError(t, err)
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, e.args, append(test.args, "penalty", "3", "penalty_ttl", "60", "now", float64(test.time.Unix())))
+	}
+
+	// Windows are converted with integer precision.
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithIntegerPrecision(),
+		limiter.WithClock(fixedClock(time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC))), limiter.WithSchedule(nil, weekends))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args[:3], []any{int64(1000000), "2000000", "16000000"})
+}
+
+func TestRollover(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithRollover(2, 10))
+	assert.Error(t, err)
+	_, err = limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithRollover(0.5, 0))
+	assert.Error(t, err)
+
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithRollover(0.5, 10))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "rollover", "0.5", "rollover_cap", "10"})
+}
+
+func TestQuota(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithQuota(0, limiter.Monthly))
+	assert.Error(t, err)
+	_, err = limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithQuota(100, 0))
+	assert.Error(t, err)
+
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC) // Wednesday
+	for _, test := range []struct {
+		period limiter.Period
+		id     string
+		end    time.Time
+	}{
+		{limiter.Daily, "2024-02-14", time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{limiter.Weekly, "2024-02-12", time.Date(2024, 2, 19, 0, 0, 0, 0, time.UTC)},
+		{limiter.Monthly, "2024-02", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{limiter.Hourly, "2024-02-14T12", time.Date(2024, 2, 14, 13, 0, 0, 0, time.UTC)},
+	} {
+		e := &envTester{}
+		l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithClock(fixedClock(now)),
+			limiter.WithQuota(1000000, test.period), limiter.WithBanning(1, time.Minute, time.Hour))
+		assert.NoError(t, err)
+
+		// The usage keys follow any ban records.
+		_, err = l.TestKeys(context.Background(), []string{"a", "b"}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, e.keys, []string{"a", "b", "a\x00ban", "b\x00ban", "a\x00quota:" + test.id, "b\x00quota:" + test.id})
+		assert.Equal(t, e.args[len(e.args)-2:], []any{"quota_end", float64(test.end.Unix())})
+		assert.Contains(t, e.args, "quota")
+
+		// The period cannot be changed by a reload, but the limit can.
+		assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithBanning(1, time.Minute, time.Hour)))
+		assert.NoError(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithBanning(1, time.Minute, time.Hour),
+			limiter.WithQuota(10, test.period)))
+	}
+}
+
+func TestSpikeArrest(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 60, Flow: 1}, limiter.WithSpikeArrest(10, 0))
+	assert.Error(t, err)
+	_, err = limiter.New(&envTester{}, limiter.Rate{Burst: 60, Flow: 1}, limiter.WithSpikeArrest(0, time.Second))
+	assert.Error(t, err)
+
+	// The spike arrest is an additional, faster bucket.
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 60, Flow: 1}, limiter.WithSpikeArrest(10, 100*time.Millisecond))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "1", "60", "100", "10"})
+}
+
+func TestHotKeySharding(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithHotKeySharding(1, func(string) bool { return true }))
+	assert.Error(t, err)
+	_, err = limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithHotKeySharding(4, nil))
+	assert.Error(t, err)
+
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 40, Flow: 1}, limiter.WithHashTags(),
+		limiter.WithHotKeySharding(4, func(key string) bool { return key == "hot" }))
+	assert.NoError(t, err)
+
+	// Hot keys are tested against a random shard, with a fraction of the rate.
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		_, err = l.Test(context.Background(), "hot", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, e.args, []any{1.0, "0.25", "10"})
+		seen[e.keys[0]] = true
+	}
+	assert.Equal(t, seen, map[string]bool{"{hot\x00shard0}": true, "{hot\x00shard1}": true, "{hot\x00shard2}": true, "{hot\x00shard3}": true})
+
+	// The cost must fit within a shard.
+	_, err = l.Peek(context.Background(), "hot", 20)
+	assert.Error(t, err)
+
+	// Other keys are unaffected.
+	_, err = l.Test(context.Background(), "cold", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"{cold}"})
+	assert.Equal(t, e.args, []any{1.0, "1", "40"})
+}
+
+func TestFairSharing(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithFairSharing("active", 0))
+	assert.Error(t, err)
+
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithFairSharing("active", time.Minute),
+		limiter.WithWeights("weights"), limiter.WithAuditStream("audit", 1000))
+	assert.NoError(t, err)
+
+	// The sets follow the weights hash, and precede the stream.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"key", "weights", "active", "active\x00demand", "audit"})
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "audit", "1000", "weights", "1", "fair", "60"})
+
+	// The set cannot be changed by a reload.
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithWeights("weights"),
+		limiter.WithAuditStream("audit", 1000)))
+	assert.NoError(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithWeights("weights"),
+		limiter.WithAuditStream("audit", 1000), limiter.WithFairSharing("active", time.Hour)))
+}
+
+func TestHealth(t *testing.T) {
+	r := &retryTester{err: errors.New("ERR syntax error"), failures: 1}
+	l, err := limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	assert.Equal(t, l.Health(), limiter.Health{})
+
+	// Failures are reported until a call succeeds.
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.Error(t, err)
+	h := l.Health()
+	assert.Equal(t, h.State, limiter.HealthFailing)
+	assert.Equal(t, h.LastError, r.err)
+	assert.False(t, h.Since.IsZero())
+	assert.True(t, h.LastSuccess.IsZero())
+
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	h = l.Health()
+	assert.Equal(t, h.State, limiter.HealthNormal)
+	assert.Equal(t, h.State.String(), "normal")
+	assert.Equal(t, h.LastError, r.err)
+	assert.False(t, h.LastSuccess.Before(h.LastFailure))
+
+	// Calls served by the fallback are reported.
+	l, err = limiter.New(fallbackTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithLocalFallback(2))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, l.Health().State, limiter.HealthFallback)
+
+	// Shards which have failed are reported as circuit-open.
+	s, err := limiter.NewSharded([]limiter.Eval{&retryTester{err: errors.New("ERR syntax error"), failures: 1}},
+		limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = s.Test(context.Background(), "key", 1)
+	assert.Error(t, err)
+	h = s.Health()
+	assert.Equal(t, h.State, limiter.HealthCircuitOpen)
+	assert.Equal(t, h.State.String(), "circuit-open")
+	assert.Error(t, h.LastError)
+}
+
+type staticTester struct{ reply any }
+
+func (t staticTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return t.reply, nil
+}
+
+func TestAllocations(t *testing.T) {
+	l, err := limiter.New(staticTester{[]any{int64(1), "3", int64(1), "1", "0"}}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	// Only the parsing of the reply may allocate, for whole-number costs.
+	ctx := context.Background()
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := l.Test(ctx, "key", 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.LessOrEqual(t, allocs, 1.0)
+}
+
+func TestResultFormatting(t *testing.T) {
+	res := limiter.Result{Allow: true, Free: 2.5, Reset: 30 * time.Second, Bucket: 1}
+	assert.Equal(t, res.String(), "allow (free 2.5, reset 30s, bucket 1)")
+	data, err := json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), `{"allow":true,"free":2.5,"wait":0,"reset":30,"bucket":1}`)
+
+	// Denials include the wait and the time at which the cost would be allowed.
+	res = limiter.Result{Wait: 1500 * time.Millisecond, Reset: time.Minute, RetryAt: time.Unix(1000, 0).UTC(), Bucket: 2}
+	assert.Equal(t, res.String(), "deny (wait 1.5s, reset 1m0s, bucket 2)")
+	data, err = json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), `{"allow":false,"free":0,"wait":1.5,"reset":60,"retryAt":"1970-01-01T00:16:40Z","bucket":2}`)
+
+	// Bypassed keys have infinite capacity, which is encoded as null.
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithBypass(func(string) bool { return true }))
+	assert.NoError(t, err)
+	res, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	data, err = json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), `{"allow":true,"free":null,"wait":0,"reset":0,"bucket":0}`)
+}
+
+func TestBuckets(t *testing.T) {
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 1},
+		limiter.WithAdditionalBucket(limiter.Rate{Burst: 8, Flow: 0.1}),
+		limiter.WithAdditionalBucket(limiter.Rate{Burst: 10, Flow: 2}))
+	assert.NoError(t, err)
+
+	// The buckets are sorted, and superfluous buckets are removed.
+	assert.Equal(t, l.Buckets(), []limiter.Rate{{Flow: 0.1, Burst: 8}, {Flow: 1, Burst: 4}})
+
+	assert.NoError(t, l.Reload(limiter.Rate{Burst: 2, Flow: 0.5}))
+	assert.Equal(t, l.Buckets(), []limiter.Rate{{Flow: 0.5, Burst: 2}})
+}
+
+type regionTester struct {
+	mu      sync.Mutex
+	args    []any
+	demands []any
+}
+
+func (t *regionTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if strings.HasPrefix(script, "redis.call('hset'") {
+		t.demands = append([]any{args[0], args[1]}, t.demands...)
+		return t.demands, nil
+	}
+	if strings.HasPrefix(script, "return redis.call('hgetall'") {
+		return t.demands, nil
+	}
+	t.args = args
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestRegions(t *testing.T) {
+	// Fails with a share which is out of range.
+	_, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 8, Flow: 1}, limiter.WithRegion("east", 1.5))
+	assert.Error(t, err)
+
+	// Fails to watch without a region.
+	l, err := limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 8, Flow: 1})
+	assert.NoError(t, err)
+	assert.Error(t, l.WatchRegions(context.Background(), time.Millisecond, "regions"))
+
+	// The region enforces its share of the buckets.
+	east, west := &regionTester{}, &regionTester{demands: []any{"west", "0"}}
+	l, err = limiter.New(east, limiter.Rate{Burst: 8, Flow: 1}, limiter.WithRegion("east", 0.5))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, east.args, []any{1.0, "0.5", "4"})
+	assert.Equal(t, l.Buckets(), []limiter.Rate{{Flow: 0.5, Burst: 4}})
+
+	// The share grows with the demand of the region, relative to the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, l.WatchRegions(ctx, 10*time.Millisecond, "regions", west))
+	assert.Eventually(t, func() bool {
+		_, err = l.Test(context.Background(), "key", 1)
+		assert.NoError(t, err)
+		east.mu.Lock()
+		defer east.mu.Unlock()
+		return east.args[1] == "0.75"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, east.args, []any{1.0, "0.75", "6"})
+}
+
+func TestActiveActive(t *testing.T) {
+	// Fails with a name which would be taken for a bucket.
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithActiveActive("1"))
+	assert.Error(t, err)
+
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithActiveActive("east"))
+	assert.NoError(t, err)
+	_, err = l.Test(context.Background(), "key", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4", "replica", "east"})
+
+	// The replica cannot be changed.
+	assert.Error(t, l.Reload(limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithActiveActive("west")))
+	assert.NoError(t, l.Reload(limiter.Rate{Burst: 8, Flow: 0.1}, limiter.WithActiveActive("east")))
+}
+
+func TestQuorum(t *testing.T) {
+	// Fails with no clients.
+	_, err := limiter.NewQuorum(nil, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.Error(t, err)
+
+	allow := func(free string) limiter.Eval { return staticTester{[]any{int64(1), free, int64(1), "1", "0"}} }
+	deny := func(fit string) limiter.Eval { return staticTester{[]any{int64(0), "2", int64(1), "1", fit}} }
+	down := &shardTester{fail: true}
+	test := func(clients ...limiter.Eval) (limiter.Result, error) {
+		q, err := limiter.NewQuorum(clients, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithExactBackoff())
+		assert.NoError(t, err)
+		return q.Test(context.Background(), "key", 1)
+	}
+
+	// A majority allows the action, despite a failure.
+	res, err := test(allow("3"), down, allow("2"))
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.Equal(t, res.Free, 2.0)
+
+	// A majority denies the action; it waits until enough would allow it.
+	res, err = test(deny("5"), deny("3"), allow("1"))
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+	assert.Equal(t, res.Wait, 3*time.Second)
+	res, err = test(deny("5"), deny("3"), deny("4"))
+	assert.NoError(t, err)
+	assert.Equal(t, res.Wait, 4*time.Second)
+
+	// Without a majority allowing the action, it is denied.
+	res, err = test(allow("3"), down, deny("5"))
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+	assert.Equal(t, res.Wait, 5*time.Second)
+
+	// Without a majority replying, the failures are returned.
+	_, err = test(allow("3"), down, down)
+	assert.EqualError(t, err, "connection refused\nconnection refused")
+}
+
+func TestHMACKeys(t *testing.T) {
+	// Fails with no secret.
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithHMACKeys(nil))
+	assert.Error(t, err)
+
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("p:"), limiter.WithHMACKeys([]byte("secret")))
+	assert.NoError(t, err)
+
+	// The key is replaced by its HMAC, after the prefix.
+	_, err = l.Test(context.Background(), "alice@example.com", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.keys, []string{"p:o5jUnOGYCzZCvE29EQEh48lT4erbSX1Q3qI-lhH4Puc"})
+
+	// Scanned keys are already HMACs, so they are not hashed again.
+	k := &keysTester{t, []string{"prefix*:{user}"}}
+	l, err = limiter.New(k, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix*:"), limiter.WithHashTags(), limiter.WithHMACKeys([]byte("secret")))
+	assert.NoError(t, err)
+	states, err := l.Keys(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, states, []limiter.KeyState{{Key: "user", Levels: []float64{2}, Free: 2, TTL: 30 * time.Second, Age: 100 * time.Second}})
+
+	r := &resetTester{T: t, keys: []string{"prefix:tenant-1"}}
+	l, err = limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithPrefix("prefix:"), limiter.WithHMACKeys([]byte("secret")))
+	assert.NoError(t, err)
+	n, err := l.ResetAll(context.Background(), "tenant-*")
+	assert.NoError(t, err)
+	assert.Equal(t, n, 1)
+	assert.Equal(t, r.deleted, [][]string{{"prefix:tenant-1"}})
+}
+
+func TestIPKey(t *testing.T) {
+	for _, test := range []struct{ addr, key string }{
+		{"192.0.2.77", "192.0.2.0/24"},
+		{"192.0.2.77:8080", "192.0.2.0/24"},
+		{"::ffff:192.0.2.77", "192.0.2.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1::/48"},
+		{"[2001:db8:1:2::6%eth0]:443", "2001:db8:1::/48"},
+	} {
+		key, err := limiter.IPKey(test.addr, 24, 48)
+		assert.NoError(t, err)
+		assert.Equal(t, key, test.key)
+	}
+
+	// Full prefixes give the address alone.
+	key, err := limiter.IPKey("2001:db8::1", 32, 128)
+	assert.NoError(t, err)
+	assert.Equal(t, key, "2001:db8::1")
+
+	// Fails with an invalid address or prefix.
+	_, err = limiter.IPKey("example.com", 24, 48)
+	assert.Error(t, err)
+	_, err = limiter.IPKey("192.0.2.77", 33, 48)
+	assert.Error(t, err)
+}
+
+func TestKeyBuilder(t *testing.T) {
+	b := limiter.NewKeyBuilder(0)
+	assert.Equal(t, b.Add("tenant").Add("user:1{a}*").Add("/api").String(), "tenant:user%3A1%7Ba%7D%2A:/api")
+
+	// Long keys are truncated, but remain distinct.
+	b = limiter.NewKeyBuilder(32)
+	long := b.Add("tenant").Add(strings.Repeat("x", 40)).String()
+	assert.Len(t, long, 32)
+	assert.True(t, strings.HasPrefix(long, "tenant:xxxxxxxx#"))
+	b.Reset()
+	assert.NotEqual(t, b.Add("tenant").Add(strings.Repeat("x", 41)).String(), long)
+
+	// Short maximums truncate the hash as well.
+	short := limiter.NewKeyBuilder(8).Add("tenant").Add("user").String()
+	assert.Len(t, short, 8)
+	assert.True(t, strings.HasPrefix(short, "#"))
+
+	// Reused builders only allocate the key.
+	allocs := testing.AllocsPerRun(100, func() {
+		b.Reset()
+		_ = b.Add("tenant").Add("user").Add("endpoint").String()
+	})
+	assert.Equal(t, allocs, 1.0)
+}
+
+type meterTester struct {
+	envTester
+	usage map[string][]any
+}
+
+func (t *meterTester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	if strings.HasPrefix(script, "return redis.call('hmget'") {
+		if usage, ok := t.usage[keys[0]]; ok {
+			return usage, nil
+		}
+		return []any{nil, nil}, nil
+	}
+	return t.envTester.Eval(ctx, script, append([]string(nil), keys...), args)
+}
+
+func TestMetering(t *testing.T) {
+	_, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithMetering(limiter.Daily, 0))
+	assert.Error(t, err)
+
+	// Fails to report usage without metering.
+	l, err := limiter.New(&envTester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	_, err = l.Usage(context.Background(), "a", time.Now(), time.Now())
+	assert.Error(t, err)
+
+	now := time.Date(2024, 2, 14, 12, 30, 0, 0, time.UTC)
+	m := &meterTester{usage: map[string][]any{"a\x00usage:2024-02-14T11": {"12.5", "3"}}}
+	l, err = limiter.New(m, limiter.Rate{Burst: 4, Flow: 0.1}, limiter.WithClock(fixedClock(now)),
+		limiter.WithQuota(100, limiter.Daily), limiter.WithMetering(limiter.Hourly, 48*time.Hour))
+	assert.NoError(t, err)
+
+	// The usage keys follow any quota keys.
+	_, err = l.TestKeys(context.Background(), []string{"a", "b"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, m.keys, []string{"a", "b", "a\x00quota:2024-02-14", "b\x00quota:2024-02-14",
+		"a\x00usage:2024-02-14T12", "b\x00usage:2024-02-14T12"})
+	assert.Equal(t, m.args[3:7], []any{"quota", "100", "meter", "172800"})
+
+	// Usage is reported for each period in the range.
+	usage, err := l.Usage(context.Background(), "a", now.Add(-90*time.Minute), now)
+	assert.NoError(t, err)
+	assert.Equal(t, usage, []limiter.Usage{
+		{Start: time.Date(2024, 2, 14, 11, 0, 0, 0, time.UTC), Allowed: 12.5, Denied: 3},
+		{Start: time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)},
+	})
+}
+
+func TestSettle(t *testing.T) {
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	// Adjustments may be negative or exceed the burst, but must be finite.
+	_, err = l.Settle(context.Background(), "a", -2)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{-2.0, "0.1", "4", "settle", 1})
+	_, err = l.Settle(context.Background(), "a", 10)
+	assert.NoError(t, err)
+	_, err = l.Settle(context.Background(), "a", math.NaN())
+	assert.Error(t, err)
+
+	l, err = limiter.New(limiter.NewMemory(), limiter.Rate{Burst: 4, Flow: 0.1},
+		limiter.WithClock(fixedClock(time.Unix(1000, 0))))
+	assert.NoError(t, err)
+
+	res, err := l.Test(context.Background(), "a", 4)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+
+	// Overestimates are refunded.
+	res, err = l.Settle(context.Background(), "a", -3)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.Equal(t, res.Free, 3.0)
+
+	// Underestimates are charged beyond the burst, and are never denied.
+	res, err = l.Settle(context.Background(), "a", 5)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.Equal(t, res.Free, -2.0)
+	res, err = l.Test(context.Background(), "a", 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allow)
+
+	// Refunds do not take the level below empty.
+	res, err = l.Settle(context.Background(), "a", -10)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Free, 4.0)
+}
+
+func TestUnlimited(t *testing.T) {
+	// Unlimited buckets are ignored alongside others, rather than superfluous.
+	assert.NoError(t, limiter.Validate(limiter.Unlimited, limiter.WithAdditionalBucket(limiter.Rate{Burst: 4, Flow: 0.1})))
+	e := &envTester{}
+	l, err := limiter.New(e, limiter.Unlimited, limiter.WithAdditionalBucket(limiter.Rate{Burst: 4, Flow: 0.1}))
+	assert.NoError(t, err)
+	assert.Equal(t, l.Buckets(), []limiter.Rate{{Burst: 4, Flow: 0.1}})
+	_, err = l.Test(context.Background(), "a", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, e.args, []any{1.0, "0.1", "4"})
+
+	// Limiters with only unlimited buckets never call Redis.
+	e = &envTester{}
+	t.Setenv("LIMITER_TEST_BUCKETS", "unlimited")
+	bucket, config, err := limiter.ConfigFromEnv("LIMITER_TEST")
+	assert.NoError(t, err)
+	l, err = limiter.New(e, bucket, config)
+	assert.NoError(t, err)
+	assert.Empty(t, l.Buckets())
+	res, err := l.Test(context.Background(), "a", 100)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	assert.Equal(t, res.Free, math.Inf(1))
+	res, err = l.Peek(context.Background(), "a", 100)
+	assert.NoError(t, err)
+	assert.True(t, res.Allow)
+	var b limiter.Batch
+	b.Test(l, "a", 100)
+	results, err := b.Exec(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, results[0].Allow)
+	assert.Nil(t, e.keys)
 }
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package limitertest provides utilities for deterministic tests of limiters,
+// by controlling the time observed by the script.
+package limitertest
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Framework wraps a Redis client, patching the script so that its time is
+	// read from a list in Redis which is advanced by Sleep, rather than from
+	// the server clock. It implements limiter.Eval, so it may be passed to
+	// limiter.New in place of the client.
+	Framework struct {
+		tb      testing.TB
+		redis   limiter.Eval
+		seconds float64
+		time    string
+		key     string
+		started bool
+	}
+
+	// Clock is a limiter.Clock which only advances when told to, for use with
+	// limiter.WithClock. It is safe for concurrent use.
+	Clock struct {
+		mu  sync.Mutex
+		now time.Time
+	}
+)
+
+var (
+	_ limiter.Eval  = (*Framework)(nil)
+	_ limiter.Clock = (*Clock)(nil)
+)
+
+const push = `return redis.call('lpush', KEYS[1], ARGV[1], ARGV[2])`
+
+// New creates a framework for the given test using the given client, with
+// keys named for the test. The time starts at one second, and the keys are
+// deleted when the test completes. The framework is not safe for concurrent
+// use.
+func New(tb testing.TB, redis limiter.Eval) *Framework {
+	f := &Framework{
+		tb:      tb,
+		redis:   redis,
+		seconds: 1,
+		time:    "redis-bucket-test:time:" + tb.Name(),
+		key:     "redis-bucket-test:key:" + tb.Name(),
+	}
+	tb.Cleanup(func() { f.Done(context.Background()) })
+	return f
+}
+
+// Key returns a key unique to the test.
+func (f *Framework) Key() string {
+	return f.key
+}
+
+// Now returns the current time observed by the script, in seconds.
+func (f *Framework) Now() float64 {
+	return f.seconds
+}
+
+// Sleep advances the time observed by the script by the given seconds.
+func (f *Framework) Sleep(ctx context.Context, s float64) {
+	f.seconds += s
+	if f.started {
+		if err := f.push(ctx); err != nil {
+			f.tb.Errorf("limitertest: failed to set time: %v", err)
+		}
+	}
+}
+
+// Done deletes the keys used by the test.
+func (f *Framework) Done(ctx context.Context) {
+	_, _ = f.redis.Eval(ctx, `return redis.call('del', KEYS[1], KEYS[2])`, []string{f.key, f.time}, nil)
+}
+
+// Eval implements limiter.Eval.
+func (f *Framework) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	// The time is only stored once the script is first called.
+	if !f.started {
+		if err := f.push(ctx); err != nil {
+			return nil, err
+		}
+		f.started = true
+	}
+
+	// Patch the script, using a list to perform a mock of the time function.
+	script = strings.Replace(script, "'time'", "'lrange','"+f.time+"',0,1", -1)
+	return f.redis.Eval(ctx, script, keys, args)
+}
+
+func (f *Framework) push(ctx context.Context) error {
+	full, part := math.Modf(f.seconds)
+	_, err := f.redis.Eval(ctx, push, []string{f.time}, []any{int(math.Floor(part * 1e6)), int(full)})
+	return err
+}
+
+// NewClock creates a clock starting at the given time.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now implements limiter.Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by the given duration.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
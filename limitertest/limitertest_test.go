@@ -0,0 +1,22 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limitertest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plsmphnx/go-redis-bucket/limitertest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := limitertest.NewClock(start)
+	assert.Equal(t, c.Now(), start)
+
+	c.Advance(time.Second)
+	assert.Equal(t, c.Now(), start.Add(time.Second))
+}
@@ -0,0 +1,38 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+)
+
+type logger struct {
+	*slog.Logger
+	level slog.Level
+}
+
+// WithLogger logs denials at the given level, failed tests at the error level
+// and superfluous buckets at the warning level, using the given logger. Keys
+// are logged as a hash rather than directly, as they often identify users.
+func WithLogger(log *slog.Logger, level slog.Level) Config {
+	return func(c *config) { c.logger = &logger{log, level} }
+}
+
+func (l *logger) log(ctx context.Context, key string, cost float64, res Result, err error) {
+	switch {
+	case err != nil:
+		l.LogAttrs(ctx, slog.LevelError, "rate limit test failed",
+			slog.String("key_hash", strconv.FormatUint(hash(key), 16)),
+			slog.Float64("cost", cost),
+			slog.Any("error", err))
+	case !res.Allow:
+		l.LogAttrs(ctx, l.level, "rate limit exceeded",
+			slog.String("key_hash", strconv.FormatUint(hash(key), 16)),
+			slog.Float64("cost", cost),
+			slog.Int("bucket", res.Bucket),
+			slog.Duration("wait", res.Wait))
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Memory is an in-process implementation of the Eval interface, which
+	// evaluates the bucket script without Redis, so that applications may
+	// exercise their limiters hermetically in unit tests. It supports the
+	// buckets, penalty box, warm-up, deduplication, peeking, settling and
+	// integer precision (although evaluated in floating point); denials are not
+	// audited, and EVAL calls other than for the bucket script are not
+	// supported. It is safe for concurrent use, but state is not shared
+	// between instances.
+	Memory struct {
+		mu    sync.Mutex
+		now   func() time.Time
+		keys  map[string]*state
+		dedup map[string]*prior
+		sweep time.Time
+	}
+
+	state struct {
+		last    float64
+		deny    float64
+		levels  []float64
+		strikes int
+		penalty float64
+		born    float64
+		expire  float64
+	}
+
+	prior struct {
+		free   float64
+		index  int
+		expire float64
+	}
+)
+
+var _ Eval = (*Memory)(nil)
+
+// NewMemory creates an empty in-process implementation of the bucket script.
+// Time is read from the system clock, unless supplied using WithClock.
+func NewMemory() *Memory {
+	return &Memory{now: time.Now, keys: map[string]*state{}, dedup: map[string]*prior{}}
+}
+
+// Eval implements Eval, ignoring the script itself.
+func (m *Memory) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	cost := number(args[0])
+	var flows, bursts []float64
+	opts := map[string]float64{}
+	for i := 1; i+1 < len(args); i += 2 {
+		// As in the script, options are named by arguments which are not numbers.
+		if name, ok := args[i].(string); ok && !numeric(name) {
+			opts[name] = number(args[i+1])
+		} else {
+			flows, bursts = append(flows, number(args[i])), append(bursts, number(args[i+1]))
+		}
+	}
+
+	// Integer precision is accepted, but evaluated in floating point.
+	_, integer := opts["integer"]
+	if integer {
+		cost /= microUnits
+		for n := range flows {
+			flows[n], bursts[n] = flows[n]/microUnits, bursts[n]/microUnits
+		}
+	}
+	reply := func(allow bool, value float64, index int, drain float64, fit float64) []any {
+		res := []any{int64(0), int64(math.Round(value * microUnits)), int64(index), int64(math.Ceil(drain * 1000)), int64(math.Ceil(fit * 1000))}
+		if allow {
+			res[0] = int64(1)
+		}
+		return res
+	}
+
+	_, peek := opts["peek"]
+	_, settle := opts["settle"]
+	// Weights, banning, quotas, metering and fair sharing are not supported,
+	// so their keys are ignored like the stream; nor are notifications
+	// published, or the states of replicas kept separately.
+	if _, ok := opts["audit"]; ok {
+		keys = keys[:len(keys)-1]
+	}
+	if _, ok := opts["fair"]; ok {
+		keys = keys[:len(keys)-1]
+	}
+	if _, ok := opts["weights"]; ok {
+		keys = keys[:len(keys)-1]
+	}
+	per := 1
+	for _, name := range []string{"ban", "quota", "meter"} {
+		if _, ok := opts[name]; ok {
+			per++
+		}
+	}
+	if per > 1 {
+		keys = keys[:len(keys)-len(keys)/per*(per-1)]
+	}
+	var dedup string
+	if _, ok := opts["dedup"]; ok {
+		keys, dedup = keys[:len(keys)-1], keys[len(keys)-1]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.now()
+	now := float64(t.UnixNano()) / 1e9
+	if clock, ok := opts["now"]; ok {
+		now = clock
+	}
+	m.expire(t, now)
+
+	// Requests which have already been allowed are not charged again.
+	if dedup != "" {
+		if p, ok := m.dedup[dedup]; ok && p.expire > now {
+			return reply(true, p.free, p.index, 0, 0), nil
+		}
+	}
+
+	ramp, start := opts["warmup_ttl"], opts["warmup"]
+	threshold, duration := int(opts["penalty"]), opts["penalty_ttl"]
+
+	states := make([]*state, len(keys))
+	levels := make([][]float64, len(keys))
+	fills := make([][]float64, len(keys))
+	free, index, worst := math.Inf(1), 0, 0
+	drainAllow, drainDeny, fit, ttl := 0.0, 0.0, 0.0, 0.0
+	for k, key := range keys {
+		s, ok := m.keys[key]
+		if !ok || s.expire < now {
+			s = &state{last: now, born: now}
+		}
+		// Times ahead of the current time are clamped, as in the script.
+		s.born = math.Min(s.born, now)
+		if duration > 0 {
+			s.penalty = math.Min(s.penalty, now+duration)
+		}
+		if s.penalty > now && !settle {
+			wait := s.penalty - now
+			return reply(false, wait, 0, wait, wait), nil
+		}
+		states[k] = s
+		levels[k], fills[k] = make([]float64, len(flows)), make([]float64, len(flows))
+
+		// New keys start with a reduced burst which grows over the warm-up.
+		scale := 1.0
+		if ramp > 0 && now-s.born < ramp {
+			scale = start + (1-start)*(now-s.born)/ramp
+			ttl = math.Max(ttl, s.born+ramp-now)
+		}
+
+		for n := range flows {
+			if n < len(s.levels) {
+				levels[k][n] = math.Max(0, s.levels[n]-math.Max(0, now-s.last)*flows[n])
+			}
+			fills[k][n] = levels[k][n] + cost
+			if settle {
+				fills[k][n] = math.Max(0, fills[k][n])
+			}
+			if bursts[n]*scale-fills[k][n] < free {
+				free, index, worst = bursts[n]*scale-fills[k][n], n+1, k
+			}
+			ttl = math.Max(ttl, math.Max(bursts[n], fills[k][n])/flows[n])
+			drainAllow = math.Max(drainAllow, fills[k][n]/flows[n])
+			drainDeny = math.Max(drainDeny, levels[k][n]/flows[n])
+			fit = math.Max(fit, (fills[k][n]-bursts[n]*scale)/flows[n])
+		}
+	}
+
+	if peek {
+		if free >= 0 {
+			return reply(true, free, index, drainDeny, 0), nil
+		}
+		return reply(false, states[worst].deny+cost, index, drainDeny, fit), nil
+	}
+
+	if free >= 0 || settle {
+		for k, key := range keys {
+			// Settling leaves any denials and penalty as they were.
+			s := &state{last: now, levels: fills[k], born: states[k].born, expire: now + ttl}
+			if settle {
+				s.deny, s.strikes, s.penalty = states[k].deny, states[k].strikes, states[k].penalty
+				s.expire = math.Max(s.expire, s.penalty)
+			}
+			m.keys[key] = s
+		}
+		if dedup != "" {
+			m.dedup[dedup] = &prior{free, index, now + opts["dedup"]}
+		}
+		return reply(true, free, index, drainAllow, 0), nil
+	}
+
+	// Only the most restrictive key is charged with the denial.
+	prev := states[worst]
+	s := &state{last: now, deny: prev.deny + cost, levels: levels[worst], strikes: prev.strikes + 1, born: prev.born, expire: now + ttl}
+	m.keys[keys[worst]] = s
+	if threshold > 0 && s.strikes >= threshold {
+		s.strikes, s.penalty, s.expire = 0, now+duration, math.Max(s.expire, now+duration)
+		return reply(false, duration, 0, duration, duration), nil
+	}
+	return reply(false, s.deny, index, drainDeny, fit), nil
+}
+
+// Expired keys are removed periodically, rather than on every call.
+func (m *Memory) expire(t time.Time, now float64) {
+	if t.Sub(m.sweep) < time.Minute {
+		return
+	}
+	m.sweep = t
+	for key, s := range m.keys {
+		if s.expire < now {
+			delete(m.keys, key)
+		}
+	}
+	for key, p := range m.dedup {
+		if p.expire < now {
+			delete(m.dedup, key)
+		}
+	}
+}
+
+func number(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+func numeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func (m *Memory) forget(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+}
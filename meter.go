@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+type (
+	// Usage describes the cost tested for a key within a metering period.
+	Usage struct {
+		// Start is the start of the period.
+		Start time.Time
+
+		// Allowed is the total cost of the calls which were allowed.
+		Allowed float64
+
+		// Denied is the total unweighted cost of the calls which were denied.
+		Denied float64
+	}
+
+	meter struct {
+		period    Period
+		retention time.Duration
+	}
+)
+
+const meterLoad = `return redis.call('hmget', KEYS[1], 'allowed', 'denied')`
+
+// WithMetering counts the cost allowed and denied for each key within each
+// calendar period, in a hash derived from the key which is kept for the given
+// retention (and is neither reported by Keys nor deleted by ResetAll), so that
+// usage may be reported (such as for billing) by the same calls which limit
+// it. Allowed costs are counted with their weights, whereas denied costs are
+// counted unweighted, as a denial may precede the weighting. The usage is read
+// using Usage. The retention may be changed by Reload, but the period may not.
+func WithMetering(period Period, retention time.Duration) Config {
+	return func(c *config) { c.meter = meter{period, retention} }
+}
+
+func (m meter) args() ([]any, error) {
+	if m == (meter{}) {
+		return nil, nil
+	}
+	if m.period < Daily || m.period > Hourly {
+		return nil, errors.New("limiter: invalid metering period")
+	}
+	if m.retention <= 0 {
+		return nil, errors.New("limiter: metering retention must be positive")
+	}
+	return []any{"meter", int64(math.Ceil(m.retention.Seconds()))}, nil
+}
+
+// The kind of the records derived from the keys to count the usage of the
+// current period, if any.
+func (l *Limiter) metered() string {
+	if l.meter == 0 {
+		return ""
+	}
+	id, _ := l.meter.window(l.now())
+	return "usage:" + id
+}
+
+// Usage returns the usage of the given key in each metering period from the
+// one containing the given start until the given end, which is zero for
+// periods with no usage or beyond the retention. This requires WithMetering.
+func (l *Limiter) Usage(ctx context.Context, key string, from time.Time, to time.Time) ([]Usage, error) {
+	if l.meter == 0 {
+		return nil, errors.New("limiter: metering is not enabled")
+	}
+
+	var usage []Usage
+	for t := from; t.Before(to); {
+		id, end := l.meter.window(t)
+		raw, err := l.redis.Eval(ctx, meterLoad, []string{derive(l.key(key), "usage:"+id)}, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, ok := raw.([]any)
+		if !ok || len(res) != 2 {
+			return nil, errInvalidReply
+		}
+		u := Usage{Start: l.meter.start(t)}
+		u.Allowed, _ = parseFloat(res[0])
+		u.Denied, _ = parseFloat(res[1])
+		usage = append(usage, u)
+		t = end
+	}
+	return usage, nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "time"
+
+// Metrics receives measurements of the behavior of a limiter, for export to a
+// monitoring system. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Decision is called with the result of each test evaluated by the script,
+	// and the index of the most restrictive bucket (in the order enforced), or
+	// zero if no bucket was responsible (such as when in the penalty box).
+	Decision(res Result, bucket int)
+
+	// Call is called with the duration and outcome of each call to Redis.
+	Call(d time.Duration, err error)
+
+	// Fallback is called whenever the full script must be sent to Redis, as it
+	// was not already loaded.
+	Fallback()
+}
+
+// WithMetrics reports measurements of the behavior of the limiter.
+func WithMetrics(metrics Metrics) Config {
+	return func(c *config) { c.metrics = metrics }
+}
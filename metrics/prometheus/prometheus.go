@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package prometheus exports the measurements of rate limiters to Prometheus.
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Collectors holds the Prometheus collectors shared by all limiters, which
+	// are distinguished by the limiter label.
+	Collectors struct {
+		decisions *prometheus.CounterVec
+		calls     *prometheus.HistogramVec
+		fallbacks *prometheus.CounterVec
+	}
+
+	metrics struct {
+		decisions *prometheus.CounterVec
+		success   prometheus.Observer
+		failure   prometheus.Observer
+		fallbacks prometheus.Counter
+		name      string
+	}
+)
+
+// New creates and registers the collectors with the given registerer.
+func New(reg prometheus.Registerer) (*Collectors, error) {
+	c := &Collectors{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "redis_bucket",
+			Name:      "decisions_total",
+			Help:      "The number of rate-limiting decisions, by outcome and most restrictive bucket.",
+		}, []string{"limiter", "bucket", "decision"}),
+		calls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "redis_bucket",
+			Name:      "call_duration_seconds",
+			Help:      "The latency of calls to Redis, by outcome.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"limiter", "outcome"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "redis_bucket",
+			Name:      "script_fallbacks_total",
+			Help:      "The number of calls which sent the full script, as it was not loaded.",
+		}, []string{"limiter"}),
+	}
+	for _, collector := range []prometheus.Collector{c.decisions, c.calls, c.fallbacks} {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Metrics returns the limiter.Metrics for the limiter with the given name,
+// for use with limiter.WithMetrics.
+func (c *Collectors) Metrics(name string) limiter.Metrics {
+	return &metrics{
+		success:   c.calls.WithLabelValues(name, "success"),
+		failure:   c.calls.WithLabelValues(name, "error"),
+		fallbacks: c.fallbacks.WithLabelValues(name),
+		decisions: c.decisions,
+		name:      name,
+	}
+}
+
+func (m *metrics) Decision(res limiter.Result, bucket int) {
+	decision := "denied"
+	if res.Allow {
+		decision = "allowed"
+	}
+	m.decisions.WithLabelValues(m.name, strconv.Itoa(bucket), decision).Inc()
+}
+
+func (m *metrics) Call(d time.Duration, err error) {
+	if err != nil {
+		m.failure.Observe(d.Seconds())
+	} else {
+		m.success.Observe(d.Seconds())
+	}
+}
+
+func (m *metrics) Fallback() {
+	m.fallbacks.Inc()
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package prometheus_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	bucket "github.com/plsmphnx/go-redis-bucket/metrics/prometheus"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c, err := bucket.New(reg)
+	assert.NoError(t, err)
+
+	m := c.Metrics("test")
+	m.Decision(limiter.Result{Allow: true}, 1)
+	m.Decision(limiter.Result{Allow: false}, 2)
+	m.Call(time.Millisecond, nil)
+	m.Call(time.Millisecond, errors.New("failed"))
+	m.Fallback()
+
+	count, err := testutil.GatherAndCount(reg,
+		"redis_bucket_decisions_total", "redis_bucket_call_duration_seconds", "redis_bucket_script_fallbacks_total")
+	assert.NoError(t, err)
+	assert.Equal(t, count, 5)
+
+	// Registering the collectors twice fails.
+	_, err = bucket.New(reg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package chi provides chi middleware which rate-limits requests per route.
+package chi
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Option provides configuration values for the middleware.
+	Option func(*options)
+
+	// KeyFunc derives the rate-limiting key for a request, given the pattern of
+	// the route it matches (such as "/users/{id}"), or "" if there is none.
+	KeyFunc func(r *http.Request, pattern string) (string, error)
+
+	options struct {
+		key  KeyFunc
+		cost func(*http.Request) float64
+	}
+)
+
+// WithKey derives keys using the given function, rather than from the host of
+// the remote address and the route pattern.
+func WithKey(key KeyFunc) Option {
+	return func(o *options) { o.key = key }
+}
+
+// WithCost derives the cost of each request using the given function, rather
+// than charging 1 per request.
+func WithCost(cost func(*http.Request) float64) Option {
+	return func(o *options) { o.cost = cost }
+}
+
+// RemoteKey derives keys from the host of the remote address and the pattern,
+// so that each client is limited separately for each route.
+func RemoteKey(r *http.Request, pattern string) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host + ":" + pattern, nil
+}
+
+// Middleware rate-limits requests using the given limiter. Denied requests fail
+// with 429 Too Many Requests and a Retry-After header; requests which could
+// not be tested fail with 500 Internal Server Error. It may be used with either
+// Use or With, as the route is resolved ahead of time if necessary.
+func Middleware(l *limiter.Limiter, opts ...Option) func(http.Handler) http.Handler {
+	o := &options{key: RemoteKey, cost: func(*http.Request) float64 { return 1 }}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, err := o.key(r, Pattern(r))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			res, err := l.Test(r.Context(), key, o.cost(r))
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !res.Allow {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.Wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Pattern returns the pattern of the route matching the request. Middleware
+// registered with Use runs before routing, in which case the remainder of the
+// route is found from the current router.
+func Pattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	pattern := rctx.RoutePattern()
+	if rctx.Routes == nil || (pattern != "" && !strings.HasSuffix(pattern, "/*")) {
+		return pattern
+	}
+
+	path := rctx.RoutePath
+	if path == "" {
+		path = r.URL.RawPath
+		if path == "" {
+			path = r.URL.Path
+		}
+	}
+	found := rctx.Routes.Find(chi.NewRouteContext(), r.Method, path)
+	if found == "" {
+		return ""
+	}
+	return strings.TrimSuffix(pattern, "/*") + found
+}
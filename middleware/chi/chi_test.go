@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package chi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	limitchi "github.com/plsmphnx/go-redis-bucket/middleware/chi"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type tester struct{ keys []string }
+
+func (t *tester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.keys = append(t.keys, keys...)
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	r := &tester{}
+	l, err := limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := chi.NewRouter()
+	router.Use(limitchi.Middleware(l))
+	router.Get("/users/{id}", ok)
+	router.Route("/api", func(api chi.Router) {
+		api.With(limitchi.Middleware(l)).Get("/items/{id}", ok)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/api/items/3"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, rec.Code, http.StatusOK)
+	}
+
+	// Requests are keyed by route pattern rather than by path.
+	assert.Equal(t, r.keys, []string{
+		"192.0.2.1:/users/{id}",
+		"192.0.2.1:/users/{id}",
+		"192.0.2.1:/api/items/{id}",
+		"192.0.2.1:/api/items/{id}",
+	})
+}
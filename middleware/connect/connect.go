@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package connect provides a Connect interceptor which rate-limits handlers.
+package connect
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Option provides configuration values for the interceptor.
+	Option func(*Interceptor)
+
+	// KeyFunc derives the rate-limiting key for a call from its procedure, peer
+	// and request headers.
+	KeyFunc func(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (string, error)
+
+	// Interceptor rate-limits handlers, with a cost of 1 per call or stream.
+	// Denied calls fail with connect.CodeResourceExhausted, with the wait
+	// attached both as errdetails.RetryInfo and as a Retry-After header. Client
+	// calls are not affected.
+	Interceptor struct {
+		limiter    *limiter.Limiter
+		key        KeyFunc
+		procedures map[string]*limiter.Limiter
+	}
+)
+
+var _ connect.Interceptor = (*Interceptor)(nil)
+
+// WithKey derives keys using the given function, rather than from the host of
+// the peer address.
+func WithKey(key KeyFunc) Option {
+	return func(i *Interceptor) { i.key = key }
+}
+
+// WithProcedure limits calls to the given procedure (such as
+// "/package.Service/Method") using the given limiter, rather than the default.
+// A nil limiter exempts the procedure from rate-limiting.
+func WithProcedure(procedure string, l *limiter.Limiter) Option {
+	return func(i *Interceptor) { i.procedures[procedure] = l }
+}
+
+// PeerKey derives keys from the host of the peer address.
+func PeerKey(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (string, error) {
+	if peer.Addr == "" {
+		return "", errors.New("connect: no peer address")
+	}
+	if host, _, err := net.SplitHostPort(peer.Addr); err == nil {
+		return host, nil
+	}
+	return peer.Addr, nil
+}
+
+// NewInterceptor creates an interceptor using the given default limiter.
+func NewInterceptor(l *limiter.Limiter, opts ...Option) *Interceptor {
+	i := &Interceptor{limiter: l, key: PeerKey, procedures: map[string]*limiter.Limiter{}}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// WrapUnary implements connect.Interceptor.
+func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		if err := i.test(ctx, req.Spec(), req.Peer(), req.Header()); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// WrapStreamingClient implements connect.Interceptor.
+func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler implements connect.Interceptor.
+func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := i.test(ctx, conn.Spec(), conn.Peer(), conn.RequestHeader()); err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+func (i *Interceptor) test(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) error {
+	l, ok := i.procedures[spec.Procedure]
+	if !ok {
+		l = i.limiter
+	}
+	if l == nil {
+		return nil
+	}
+
+	key, err := i.key(ctx, spec, peer, header)
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	res, err := l.Test(ctx, key, 1)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	if res.Allow {
+		return nil
+	}
+
+	cerr := connect.NewError(connect.CodeResourceExhausted, errors.New("rate limit exceeded"))
+	if detail, err := connect.NewErrorDetail(&errdetails.RetryInfo{RetryDelay: durationpb.New(res.Wait)}); err == nil {
+		cerr.AddDetail(detail)
+	}
+	cerr.Meta().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.Wait.Seconds()))))
+	return cerr
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package connect_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	limitconnect "github.com/plsmphnx/go-redis-bucket/middleware/connect"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+type tester struct{}
+
+func (tester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	if keys[0] == "deny" {
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestInterceptor(t *testing.T) {
+	l, err := limiter.New(tester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	i := limitconnect.NewInterceptor(l, limitconnect.WithKey(
+		func(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (string, error) {
+			return header.Get("X-Key"), nil
+		}))
+	next := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return connect.NewResponse(&struct{}{}), nil
+	})
+
+	call := func(key string) error {
+		req := connect.NewRequest(&struct{}{})
+		req.Header().Set("X-Key", key)
+		_, err := next(context.Background(), req)
+		return err
+	}
+
+	assert.NoError(t, call("allow"))
+
+	// Denied calls carry the wait.
+	err = call("deny")
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	var cerr *connect.Error
+	assert.ErrorAs(t, err, &cerr)
+	assert.Equal(t, cerr.Meta().Get("Retry-After"), "40")
+	assert.Len(t, cerr.Details(), 1)
+	detail, err := cerr.Details()[0].Value()
+	assert.NoError(t, err)
+	assert.Equal(t, detail.(*errdetails.RetryInfo).RetryDelay.AsDuration(), 40*time.Second)
+}
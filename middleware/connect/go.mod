@@ -0,0 +1,19 @@
+module github.com/plsmphnx/go-redis-bucket/middleware/connect
+
+go 1.21
+
+require (
+	connectrpc.com/connect v1.16.0
+	github.com/plsmphnx/go-redis-bucket v0.0.0
+	github.com/stretchr/testify v1.8.4
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237
+	google.golang.org/protobuf v1.33.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/plsmphnx/go-redis-bucket => ../..
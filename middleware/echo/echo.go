@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package echo provides Echo middleware which rate-limits requests.
+package echo
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Option provides configuration values for the middleware.
+	Option func(*options)
+
+	options struct {
+		key  func(echo.Context) (string, error)
+		cost func(echo.Context) float64
+	}
+)
+
+// WithKey derives keys using the given function, rather than from the real IP
+// address of the client.
+func WithKey(key func(echo.Context) (string, error)) Option {
+	return func(o *options) { o.key = key }
+}
+
+// WithCost derives the cost of each request using the given function, rather
+// than charging 1 per request.
+func WithCost(cost func(echo.Context) float64) Option {
+	return func(o *options) { o.cost = cost }
+}
+
+// Middleware rate-limits requests using the given limiter. Denied requests fail
+// with 429 Too Many Requests and a Retry-After header; requests which could
+// not be tested fail with 500 Internal Server Error.
+func Middleware(l *limiter.Limiter, opts ...Option) echo.MiddlewareFunc {
+	o := &options{
+		key:  func(c echo.Context) (string, error) { return c.RealIP(), nil },
+		cost: func(echo.Context) float64 { return 1 },
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key, err := o.key(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+			}
+			res, err := l.Test(c.Request().Context(), key, o.cost(c))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+			}
+			if !res.Allow {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.Wait.Seconds()))))
+				return echo.NewHTTPError(http.StatusTooManyRequests)
+			}
+			return next(c)
+		}
+	}
+}
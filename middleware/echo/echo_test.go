@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package echo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	limitecho "github.com/plsmphnx/go-redis-bucket/middleware/echo"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type tester struct{}
+
+func (tester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	switch keys[0] {
+	case "allow":
+		return []any{int64(1), "3", int64(1)}, nil
+	case "deny":
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return nil, errors.New("failed")
+}
+
+func TestMiddleware(t *testing.T) {
+	l, err := limiter.New(tester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	e := echo.New()
+	e.Use(limitecho.Middleware(l, limitecho.WithKey(func(c echo.Context) (string, error) {
+		return c.Request().Header.Get("X-Key"), nil
+	})))
+	e.GET("/", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
+
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Key", key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, serve("allow").Code, http.StatusOK)
+
+	rec := serve("deny")
+	assert.Equal(t, rec.Code, http.StatusTooManyRequests)
+	assert.Equal(t, rec.Header().Get("Retry-After"), "40")
+
+	assert.Equal(t, serve("error").Code, http.StatusInternalServerError)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package fasthttp provides fasthttp middleware which rate-limits requests.
+package fasthttp
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Option provides configuration values for the middleware.
+	Option func(*options)
+
+	options struct {
+		key  func(*fasthttp.RequestCtx) (string, error)
+		cost func(*fasthttp.RequestCtx) float64
+	}
+)
+
+// WithKey derives keys using the given function, rather than from the remote
+// IP address.
+func WithKey(key func(*fasthttp.RequestCtx) (string, error)) Option {
+	return func(o *options) { o.key = key }
+}
+
+// WithCost derives the cost of each request using the given function, rather
+// than charging 1 per request.
+func WithCost(cost func(*fasthttp.RequestCtx) float64) Option {
+	return func(o *options) { o.cost = cost }
+}
+
+// Middleware rate-limits requests to the given handler using the given limiter.
+// Denied requests fail with 429 Too Many Requests and a Retry-After header;
+// requests which could not be tested fail with 500 Internal Server Error. The
+// request context is passed to the limiter, as it implements context.Context.
+func Middleware(l *limiter.Limiter, next fasthttp.RequestHandler, opts ...Option) fasthttp.RequestHandler {
+	o := &options{
+		key:  func(ctx *fasthttp.RequestCtx) (string, error) { return ctx.RemoteIP().String(), nil },
+		cost: func(*fasthttp.RequestCtx) float64 { return 1 },
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		key, err := o.key(ctx)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		res, err := l.Test(ctx, key, o.cost(ctx))
+		if err != nil {
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+			return
+		}
+		if !res.Allow {
+			// The header is set after the error, as that resets the response.
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusTooManyRequests), fasthttp.StatusTooManyRequests)
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(res.Wait.Seconds()))))
+			return
+		}
+		next(ctx)
+	}
+}
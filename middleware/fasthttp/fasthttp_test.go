@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package fasthttp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	limitfasthttp "github.com/plsmphnx/go-redis-bucket/middleware/fasthttp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type tester struct{}
+
+func (tester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	switch keys[0] {
+	case "allow":
+		return []any{int64(1), "3", int64(1)}, nil
+	case "deny":
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return nil, errors.New("failed")
+}
+
+func TestMiddleware(t *testing.T) {
+	l, err := limiter.New(tester{}, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+
+	handler := limitfasthttp.Middleware(l, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	}, limitfasthttp.WithKey(func(ctx *fasthttp.RequestCtx) (string, error) {
+		return string(ctx.Request.Header.Peek("X-Key")), nil
+	}))
+
+	serve := func(key string) *fasthttp.Response {
+		// The context must be initialized, as the limiter checks it for errors.
+		var req fasthttp.Request
+		req.Header.Set("X-Key", key)
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, nil, nil)
+		handler(&ctx)
+		return &ctx.Response
+	}
+
+	assert.Equal(t, serve("allow").StatusCode(), fasthttp.StatusOK)
+
+	res := serve("deny")
+	assert.Equal(t, res.StatusCode(), fasthttp.StatusTooManyRequests)
+	assert.Equal(t, string(res.Header.Peek("Retry-After")), "40")
+
+	assert.Equal(t, serve("error").StatusCode(), fasthttp.StatusInternalServerError)
+}
@@ -0,0 +1,20 @@
+module github.com/plsmphnx/go-redis-bucket/middleware/fasthttp
+
+go 1.21
+
+require (
+	github.com/plsmphnx/go-redis-bucket v0.0.0
+	github.com/stretchr/testify v1.8.4
+	github.com/valyala/fasthttp v1.52.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/klauspost/compress v1.17.6 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/plsmphnx/go-redis-bucket => ../..
@@ -0,0 +1,134 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package gqlgen provides a gqlgen extension which rate-limits operations by
+// their complexity.
+package gqlgen
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+
+	"github.com/99designs/gqlgen/complexity"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+const extension = "RateLimit"
+
+type (
+	// Option provides configuration values for the extension.
+	Option func(*Extension)
+
+	// Extension charges the complexity of each operation, as calculated by
+	// gqlgen, as the cost of a test before it is executed. Denied operations
+	// fail with a RATE_LIMITED error, with the wait in seconds as retryAfter.
+	Extension struct {
+		limiter   *limiter.Limiter
+		key       func(context.Context) (string, error)
+		fieldCost float64
+		schema    graphql.ExecutableSchema
+	}
+
+	// Stats provides the costs charged for an operation, available from the
+	// operation statistics under the extension name.
+	Stats struct {
+		Key       string
+		Estimated float64
+		Actual    float64
+	}
+
+	fieldCount struct{}
+)
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = (*Extension)(nil)
+
+// WithFieldCost enables a second phase of charging, in which each resolved
+// field costs the given amount. Once the operation completes, the cost charged
+// is settled to the actual cost: any cost beyond the estimated complexity is
+// charged, and overestimates are refunded.
+func WithFieldCost(cost float64) Option {
+	return func(e *Extension) { e.fieldCost = cost }
+}
+
+// New creates an extension using the given limiter, deriving keys from the
+// request context using the given function.
+func New(l *limiter.Limiter, key func(context.Context) (string, error), opts ...Option) *Extension {
+	e := &Extension{limiter: l, key: key}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// ExtensionName implements graphql.HandlerExtension.
+func (e *Extension) ExtensionName() string {
+	return extension
+}
+
+// Validate implements graphql.HandlerExtension.
+func (e *Extension) Validate(schema graphql.ExecutableSchema) error {
+	e.schema = schema
+	return nil
+}
+
+// MutateOperationContext charges the estimated complexity of the operation.
+func (e *Extension) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	key, err := e.key(ctx)
+	if err != nil {
+		return gqlerror.Errorf("%s", err.Error())
+	}
+	cost := float64(complexity.Calculate(e.schema, rc.Operation, rc.Variables))
+	res, err := e.limiter.Test(ctx, key, cost)
+	if err != nil {
+		return gqlerror.Errorf("rate limit test failed")
+	}
+	if !res.Allow {
+		gerr := gqlerror.Errorf("rate limit exceeded")
+		gerr.Extensions = map[string]any{
+			"code":       "RATE_LIMITED",
+			"retryAfter": int(math.Ceil(res.Wait.Seconds())),
+		}
+		return gerr
+	}
+	rc.Stats.SetExtension(extension, &Stats{Key: key, Estimated: cost})
+	return nil
+}
+
+// InterceptResponse settles the cost charged to that of the resolved fields,
+// if enabled.
+func (e *Extension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if e.fieldCost == 0 || !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	stats, ok := graphql.GetOperationContext(ctx).Stats.GetExtension(extension).(*Stats)
+	if !ok {
+		return next(ctx)
+	}
+
+	var count int64
+	res := next(context.WithValue(ctx, fieldCount{}, &count))
+
+	stats.Actual = float64(atomic.LoadInt64(&count)) * e.fieldCost
+	if delta := stats.Actual - stats.Estimated; delta != 0 {
+		// The operation has already run, so the outcome is only recorded.
+		_, _ = e.limiter.Settle(ctx, stats.Key, delta)
+	}
+	return res
+}
+
+// InterceptField counts the resolved fields, if enabled.
+func (e *Extension) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	if count, ok := ctx.Value(fieldCount{}).(*int64); ok {
+		atomic.AddInt64(count, 1)
+	}
+	return next(ctx)
+}
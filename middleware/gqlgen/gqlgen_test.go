@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package gqlgen_test
+
+import (
+	"context"
+	"testing"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	limitgqlgen "github.com/plsmphnx/go-redis-bucket/middleware/gqlgen"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/executor/testexecutor"
+	"github.com/stretchr/testify/assert"
+)
+
+type tester struct{ costs []any }
+
+func (t *tester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.costs = append(t.costs, args[0])
+	if args[0].(float64) > 10 {
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func query(exec *testexecutor.TestExecutor, q string) *graphql.Response {
+	ctx := graphql.StartOperationTrace(context.Background())
+	rc, err := exec.CreateOperationContext(ctx, &graphql.RawParams{Query: q})
+	if err != nil {
+		return exec.DispatchError(graphql.WithOperationContext(ctx, rc), err)
+	}
+	res, ctx := exec.DispatchOperation(ctx, rc)
+	return res(ctx)
+}
+
+func TestExtension(t *testing.T) {
+	r := &tester{}
+	l, err := limiter.New(r, limiter.Rate{Burst: 20, Flow: 1})
+	assert.NoError(t, err)
+
+	exec := testexecutor.New()
+	exec.SetCalculatedComplexity(1)
+	exec.Use(limitgqlgen.New(l, func(context.Context) (string, error) { return "key", nil },
+		limitgqlgen.WithFieldCost(5)))
+
+	// The complexity is charged up front, and settled to the actual cost afterwards.
+	res := query(exec, "{ name }")
+	assert.Empty(t, res.Errors)
+	assert.Equal(t, r.costs, []any{1.0, 4.0})
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package grpc provides gRPC interceptors which rate-limit calls, both on the
+// server and on the client.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type (
+	// Option provides configuration values for the interceptors.
+	Option func(*options)
+
+	// KeyFunc derives the rate-limiting key for a call from its context, which
+	// carries the incoming metadata and peer, and its full method name.
+	KeyFunc func(ctx context.Context, fullMethod string) (string, error)
+
+	options struct {
+		key     KeyFunc
+		methods map[string]*limiter.Limiter
+	}
+)
+
+// WithKey derives keys using the given function, rather than from the host of
+// the peer address (for servers) or from the full method (for clients).
+func WithKey(key KeyFunc) Option {
+	return func(o *options) { o.key = key }
+}
+
+// WithMethod limits calls to the given full method (such as
+// "/package.Service/Method") using the given limiter, rather than the default.
+// A nil limiter exempts the method from rate-limiting.
+func WithMethod(fullMethod string, l *limiter.Limiter) Option {
+	return func(o *options) { o.methods[fullMethod] = l }
+}
+
+// PeerKey derives keys from the host of the peer address.
+func PeerKey(ctx context.Context, fullMethod string) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", errors.New("grpc: no peer address")
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host, nil
+	}
+	return addr, nil
+}
+
+// MethodKey derives keys from the full method alone.
+func MethodKey(ctx context.Context, fullMethod string) (string, error) {
+	return fullMethod, nil
+}
+
+// MetadataKey derives keys from the first value of the given incoming metadata.
+func MetadataKey(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		if values := metadata.ValueFromIncomingContext(ctx, name); len(values) > 0 {
+			return values[0], nil
+		}
+		return "", errors.New("grpc: no " + name + " metadata")
+	}
+}
+
+// UnaryServerInterceptor rate-limits unary calls using the given limiter, with
+// a cost of 1 per call. Denied calls fail with codes.ResourceExhausted, with
+// the wait attached as errdetails.RetryInfo.
+func UnaryServerInterceptor(l *limiter.Limiter, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(l, PeerKey, opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := o.test(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rate-limits streams using the given limiter, with a
+// cost of 1 per stream, as with UnaryServerInterceptor.
+func StreamServerInterceptor(l *limiter.Limiter, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(l, PeerKey, opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := o.test(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// UnaryClientInterceptor throttles outgoing unary calls using the given
+// limiter, waiting until each call is allowed before sending it, with a cost of
+// 1 per call. This allows many clients to collectively respect the quota of a
+// shared dependency.
+func UnaryClientInterceptor(l *limiter.Limiter, opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(l, MethodKey, opts)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		if err := o.wait(ctx, method); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor throttles outgoing streams using the given limiter,
+// with a cost of 1 per stream, as with UnaryClientInterceptor.
+func StreamClientInterceptor(l *limiter.Limiter, opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(l, MethodKey, opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := o.wait(ctx, method); err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, callOpts...)
+	}
+}
+
+func newOptions(l *limiter.Limiter, key KeyFunc, opts []Option) *options {
+	o := &options{key: key, methods: map[string]*limiter.Limiter{"": l}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) forMethod(fullMethod string) *limiter.Limiter {
+	if l, ok := o.methods[fullMethod]; ok {
+		return l
+	}
+	return o.methods[""]
+}
+
+func (o *options) test(ctx context.Context, fullMethod string) error {
+	l := o.forMethod(fullMethod)
+	if l == nil {
+		return nil
+	}
+
+	key, err := o.key(ctx, fullMethod)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	res, err := l.Test(ctx, key, 1)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if res.Allow {
+		return nil
+	}
+
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(res.Wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func (o *options) wait(ctx context.Context, fullMethod string) error {
+	l := o.forMethod(fullMethod)
+	if l == nil {
+		return nil
+	}
+
+	key, err := o.key(ctx, fullMethod)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := l.Wait(ctx, key, 1); err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(err).Err()
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
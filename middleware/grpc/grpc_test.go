@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package grpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	limitgrpc "github.com/plsmphnx/go-redis-bucket/middleware/grpc"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type tester struct{ keys []string }
+
+func (t *tester) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	t.keys = append(t.keys, keys...)
+	if keys[0] == "10.0.0.2" {
+		return []any{int64(0), "2", int64(1)}, nil
+	}
+	return []any{int64(1), "3", int64(1)}, nil
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	r := &tester{}
+	l, err := limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	interceptor := limitgrpc.UnaryServerInterceptor(l, limitgrpc.WithMethod("/test.Service/Exempt", nil))
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	call := func(ip string, method string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}})
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	// Calls are keyed by the peer host.
+	assert.NoError(t, call("10.0.0.1", "/test.Service/Method"))
+
+	// Denied calls carry the wait.
+	err = call("10.0.0.2", "/test.Service/Method")
+	st := status.Convert(err)
+	assert.Equal(t, st.Code(), codes.ResourceExhausted)
+	assert.Len(t, st.Details(), 1)
+	assert.Equal(t, st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration(), 40*time.Second)
+
+	// Exempt methods are not tested.
+	assert.NoError(t, call("10.0.0.2", "/test.Service/Exempt"))
+	assert.Equal(t, r.keys, []string{"10.0.0.1", "10.0.0.2"})
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	r := &tester{}
+	l, err := limiter.New(r, limiter.Rate{Burst: 4, Flow: 0.1})
+	assert.NoError(t, err)
+	interceptor := limitgrpc.UnaryClientInterceptor(l)
+
+	var invoked int
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked++
+		return nil
+	}
+
+	// Calls are keyed by method, and sent once allowed.
+	err = interceptor(context.Background(), "/test.Service/Method", nil, nil, nil, invoker)
+	assert.NoError(t, err)
+	assert.Equal(t, invoked, 1)
+	assert.Equal(t, r.keys, []string{"/test.Service/Method"})
+}
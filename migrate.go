@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+// Times are restored relative to the current time of the destination, so that
+// the clocks of the source and destination need not agree. The state is
+// converted to ticks and the scale of the integer precision, if any.
+const migrateImport = `
+local now = tonumber(ARGV[1])
+if now == 0 then
+  local time = redis.call('time')
+  now = tonumber(time[1]) + tonumber(time[2]) / 1e6
+end
+local tick, scale = tonumber(ARGV[7]), tonumber(ARGV[8])
+local function scaled(value, factor)
+  if factor == 1 then
+    return value
+  end
+  return math.floor(value * factor + 0.5)
+end
+now = scaled(now, tick)
+local levels = {}
+for i = 9, #ARGV do
+  levels[#levels + 1] = scaled(tonumber(ARGV[i]), scale)
+end
+local penalty = tonumber(ARGV[5])
+if penalty > 0 then
+  penalty = now + scaled(penalty, tick)
+end
+redis.call('psetex', KEYS[1], ARGV[2], cmsgpack.pack(now, scaled(tonumber(ARGV[3]), scale), levels,
+  tonumber(ARGV[4]), penalty, now - scaled(tonumber(ARGV[6]), tick)))
+return 1`
+
+// Export returns the state of every key matching the given glob pattern, as
+// passed to Test (without any prefix), to be restored elsewhere using Import.
+// The state is portable between servers, as all times are relative; the
+// levels are drained according to the current buckets. This requires a client
+// supporting Scan.
+func (l *Limiter) Export(ctx context.Context, pattern string) ([]KeyState, error) {
+	if pattern == "" {
+		return nil, errors.New("limiter: must have a pattern")
+	}
+	return l.states(ctx, pattern)
+}
+
+// Import restores the state of the given keys, as returned by Export, replacing
+// any existing state. The prefix of this limiter is applied to each key, so
+// keys may be moved between prefixes. With WithHMACKeys, the keys are the
+// HMACs reported by Export, and are not hashed again. If an error occurs, the
+// keys before it have already been restored.
+func (l *Limiter) Import(ctx context.Context, states []KeyState) error {
+	now := 0.0
+	if l.clock != nil {
+		now = float64(l.clock.Now().UnixNano()) / 1e9
+	}
+	tick, scale := l.settings.Load().units()
+	for _, state := range states {
+		if state.TTL <= 0 {
+			return errors.New("limiter: imported keys must have a positive TTL")
+		}
+		args := []any{now, state.TTL.Milliseconds(), state.Denied, state.Strikes,
+			state.Penalty.Seconds(), state.Age.Seconds(), tick, scale}
+		for _, level := range state.Levels {
+			args = append(args, level)
+		}
+		if _, err := l.redis.Eval(ctx, migrateImport, []string{l.stored(state.Key)}, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "time"
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithMiniredis makes the limiter compatible with miniredis, which does not
+// provide a usable TIME within scripts, by supplying the current time from the
+// local system clock. A clock supplied using WithClock takes precedence, so
+// that tests may control time along with miniredis' own FastForward and
+// SetTime.
+func WithMiniredis() Config {
+	return func(c *config) {
+		if c.clock == nil {
+			c.clock = systemClock{}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// ScriptLoadKey represents a cluster client able to load a script onto the
+	// node which serves the given key, and to report the address of that node
+	// (or an empty address, if there is only one).
+	ScriptLoadKey interface {
+		ScriptLoadKey(ctx context.Context, key string, script string) (string, error)
+		NodeAddr(ctx context.Context, key string) (string, error)
+	}
+
+	// Tracks when the script was last loaded onto each node, so that a node
+	// missing the script (such as after a restart or failover) has it loaded
+	// once, rather than every call falling back to EVAL.
+	nodes struct {
+		loader ScriptLoadKey
+		mu     sync.Mutex
+		loaded map[string]time.Time
+	}
+)
+
+// Concurrent calls which find the script missing only load it once within
+// this interval; others simply retry.
+const reloadInterval = time.Second
+
+func newNodes(eval Eval) *nodes {
+	if loader, ok := as[ScriptLoadKey](eval); ok {
+		return &nodes{loader: loader, loaded: map[string]time.Time{}}
+	}
+	return nil
+}
+
+// Load the script onto the node serving the key, reporting whether the script
+// is expected to be available there.
+func (n *nodes) reload(ctx context.Context, key string) bool {
+	addr, err := n.loader.NodeAddr(ctx, key)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+
+	n.mu.Lock()
+	if now.Sub(n.loaded[addr]) < reloadInterval {
+		n.mu.Unlock()
+		return true
+	}
+	n.loaded[addr] = now
+	n.mu.Unlock()
+
+	sha, err := n.loader.ScriptLoadKey(ctx, key, script)
+	return err == nil && sha == sha1
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+type notify string
+
+// WithNotifications publishes a message on the given Redis channel, within the
+// same call which evaluates it, whenever a key is denied after being allowed
+// ("denied:" followed by the key) or allowed after being denied ("allowed:"
+// followed by the key), so that operational tooling may react to keys being
+// limited without polling. Denials by the penalty box, bans and quotas are not
+// transitions of the buckets, and are not published. The messages may be
+// received with WatchTransitions.
+func WithNotifications(channel string) Config {
+	return func(c *config) { c.notify = notify(channel) }
+}
+
+func (n notify) args() ([]any, error) {
+	if n == "" {
+		return nil, nil
+	}
+	// The script distinguishes options from buckets by whether they are numbers.
+	if numeric(string(n)) {
+		return nil, errors.New("limiter: notification channel must not be a number")
+	}
+	return []any{"notify", string(n)}, nil
+}
+
+// WatchTransitions calls the given function with each key, as passed to Test,
+// which is published on the given channel by a limiter configured using
+// WithNotifications, until the context is done; limited reports whether the
+// key was denied rather than allowed. Keys without the prefix of the limiter
+// are ignored. This requires a client supporting Subscribe.
+func (l *Limiter) WatchTransitions(ctx context.Context, channel string, fn func(key string, limited bool)) error {
+	sub, ok := as[Subscribe](l.redis)
+	if !ok {
+		return errors.New("limiter: watching requires a client supporting SUBSCRIBE")
+	}
+	messages, err := sub.Subscribe(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range messages {
+			event, stored, _ := strings.Cut(msg, ":")
+			if key, ok := l.unkey(stored); ok && (event == "denied" || event == "allowed") {
+				fn(key, event == "denied")
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package otel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+type metrics struct {
+	decisions metric.Int64Counter
+	waits     metric.Float64Histogram
+	calls     metric.Float64Histogram
+	errors    metric.Int64Counter
+	fallbacks metric.Int64Counter
+	name      attribute.KeyValue
+}
+
+// NewMetrics returns a limiter.Metrics, for use with limiter.WithMetrics, which
+// records measurements using instruments from the given provider. The name is
+// recorded as an attribute, to distinguish between limiters.
+func NewMetrics(mp metric.MeterProvider, name string) (limiter.Metrics, error) {
+	meter := mp.Meter(scope)
+	m := &metrics{name: attribute.String("ratelimit.limiter", name)}
+
+	var err, e error
+	m.decisions, e = meter.Int64Counter("ratelimit.decisions",
+		metric.WithDescription("The number of rate-limiting decisions, by outcome and most restrictive bucket."))
+	err = errors.Join(err, e)
+	m.waits, e = meter.Float64Histogram("ratelimit.wait", metric.WithUnit("s"),
+		metric.WithDescription("The time denied callers are asked to wait before trying again."))
+	err = errors.Join(err, e)
+	m.calls, e = meter.Float64Histogram("ratelimit.redis.duration", metric.WithUnit("s"),
+		metric.WithDescription("The latency of calls to Redis."))
+	err = errors.Join(err, e)
+	m.errors, e = meter.Int64Counter("ratelimit.redis.errors",
+		metric.WithDescription("The number of calls to Redis which failed."))
+	err = errors.Join(err, e)
+	m.fallbacks, e = meter.Int64Counter("ratelimit.script.fallbacks",
+		metric.WithDescription("The number of calls which sent the full script, as it was not loaded."))
+	err = errors.Join(err, e)
+
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *metrics) Decision(res limiter.Result, bucket int) {
+	attrs := metric.WithAttributes(m.name,
+		attribute.Int("ratelimit.bucket", bucket),
+		attribute.Bool("ratelimit.allowed", res.Allow))
+	m.decisions.Add(context.Background(), 1, attrs)
+	if !res.Allow {
+		m.waits.Record(context.Background(), res.Wait.Seconds(), metric.WithAttributes(m.name))
+	}
+}
+
+func (m *metrics) Call(d time.Duration, err error) {
+	attrs := metric.WithAttributes(m.name)
+	m.calls.Record(context.Background(), d.Seconds(), attrs)
+	if err != nil {
+		m.errors.Add(context.Background(), 1, attrs)
+	}
+}
+
+func (m *metrics) Fallback() {
+	m.fallbacks.Add(context.Background(), 1, metric.WithAttributes(m.name))
+}
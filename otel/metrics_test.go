@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package otel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	"github.com/plsmphnx/go-redis-bucket/otel"
+
+	"github.com/stretchr/testify/assert"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m, err := otel.NewMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), "test")
+	assert.NoError(t, err)
+
+	m.Decision(limiter.Result{Allow: true}, 1)
+	m.Decision(limiter.Result{Allow: false, Wait: time.Second}, 2)
+	m.Call(time.Millisecond, nil)
+	m.Call(time.Millisecond, errors.New("failed"))
+	m.Fallback()
+
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &rm))
+	assert.Len(t, rm.ScopeMetrics, 1)
+
+	names := map[string]bool{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		names[m.Name] = true
+	}
+	assert.Equal(t, names, map[string]bool{
+		"ratelimit.decisions":        true,
+		"ratelimit.wait":             true,
+		"ratelimit.redis.duration":   true,
+		"ratelimit.redis.errors":     true,
+		"ratelimit.script.fallbacks": true,
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package otel exports the behavior of rate limiters to OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+)
+
+const scope = "github.com/plsmphnx/go-redis-bucket/otel"
+
+type tracer struct{ trace.Tracer }
+
+// NewTracer returns a limiter.Tracer, for use with limiter.WithTracer, which
+// records a span for each test. Keys are hashed rather than recorded directly,
+// as they often identify users.
+func NewTracer(tp trace.TracerProvider) limiter.Tracer {
+	return tracer{tp.Tracer(scope)}
+}
+
+func (t tracer) Start(ctx context.Context, key string, cost float64) (context.Context, func(limiter.Result, error)) {
+	ctx, span := t.Tracer.Start(ctx, "limiter.Test", trace.WithAttributes(
+		attribute.String("ratelimit.key_hash", hash(key)),
+		attribute.Float64("ratelimit.cost", cost),
+	))
+	return ctx, func(res limiter.Result, err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(
+				attribute.Bool("ratelimit.allowed", res.Allow),
+				attribute.Int("ratelimit.bucket", res.Bucket),
+				attribute.Float64("ratelimit.wait", res.Wait.Seconds()),
+			)
+		}
+		span.End()
+	}
+}
+
+func hash(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
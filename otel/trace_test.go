@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package otel_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	limiter "github.com/plsmphnx/go-redis-bucket"
+	"github.com/plsmphnx/go-redis-bucket/otel"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tr := otel.NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	_, end := tr.Start(context.Background(), "key", 2)
+	end(limiter.Result{Allow: false, Wait: time.Second, Bucket: 1}, nil)
+
+	spans := rec.Ended()
+	assert.Len(t, spans, 1)
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	// The key itself is not recorded.
+	assert.NotEqual(t, attrs["ratelimit.key_hash"].AsString(), "key")
+	assert.Equal(t, attrs["ratelimit.cost"].AsFloat64(), 2.0)
+	assert.False(t, attrs["ratelimit.allowed"].AsBool())
+	assert.Equal(t, attrs["ratelimit.bucket"].AsInt64(), int64(1))
+	assert.Equal(t, attrs["ratelimit.wait"].AsFloat64(), 1.0)
+}
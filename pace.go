@@ -0,0 +1,83 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// The least time to wait between tests, when a denial does not indicate one.
+const minWait = 10 * time.Millisecond
+
+// Wait blocks until the given action is allowed, testing it again after each
+// denial once the indicated wait has elapsed, or until the context is done.
+// The cost must fit within the burst of every bucket, or it will never be
+// allowed.
+func (l *Limiter) Wait(ctx context.Context, key string, cost float64) (Result, error) {
+	for {
+		res, err := l.Test(ctx, key, cost)
+		if err != nil || res.Allow {
+			return res, err
+		}
+		wait := res.Wait
+		if wait < minWait {
+			wait = minWait
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return res, err
+		}
+	}
+}
+
+// Pace wraps a message-processing function so that messages are processed no
+// faster than the limits allow for the tenant of each, by waiting for the cost
+// of each message before processing it.
+func Pace[M any](l *Limiter, tenant func(M) string, cost func(M) float64, process func(context.Context, M) error) func(context.Context, M) error {
+	return func(ctx context.Context, msg M) error {
+		if _, err := l.Wait(ctx, tenant(msg), cost(msg)); err != nil {
+			return err
+		}
+		return process(ctx, msg)
+	}
+}
+
+// PaceBatch wraps a batch-processing function as with Pace. Each batch is
+// divided by tenant (preserving the order of messages within each), and each
+// part is processed once the total cost of its messages is allowed. The total
+// is charged in chunks of at most the smallest burst, so that a part may cost
+// more than the burst even though each of its messages fits.
+func PaceBatch[M any](l *Limiter, tenant func(M) string, cost func(M) float64, process func(context.Context, []M) error) func(context.Context, []M) error {
+	return func(ctx context.Context, batch []M) error {
+		var order []string
+		parts := map[string][]M{}
+		costs := map[string]float64{}
+		for _, msg := range batch {
+			t := tenant(msg)
+			if _, ok := parts[t]; !ok {
+				order = append(order, t)
+			}
+			parts[t] = append(parts[t], msg)
+			costs[t] += cost(msg)
+		}
+
+		for _, t := range order {
+			_, s := l.shard(t)
+			for total, burst := costs[t], s.burst(); ; {
+				chunk := math.Min(total, burst)
+				if _, err := l.Wait(ctx, t, chunk); err != nil {
+					return err
+				}
+				if total -= chunk; total <= 0 {
+					break
+				}
+			}
+			if err := process(ctx, parts[t]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
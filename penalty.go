@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+type penalty struct {
+	threshold int
+	duration  time.Duration
+}
+
+// WithPenaltyBox places a key in a penalty box once it has been denied the
+// given number of consecutive times. While in the penalty box, all calls for
+// that key are denied immediately without evaluating any buckets, and the
+// wait indicates the time remaining until the key is released.
+func WithPenaltyBox(threshold int, duration time.Duration) Config {
+	return func(c *config) { c.penalty = penalty{threshold, duration} }
+}
+
+func (p penalty) args() ([]any, error) {
+	if p.threshold == 0 && p.duration == 0 {
+		return nil, nil
+	}
+	if p.threshold <= 0 || p.duration <= 0 {
+		return nil, errors.New("limiter: penalty parameters must be positive")
+	}
+	return []any{"penalty", p.threshold, "penalty_ttl", p.duration.Seconds()}, nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+type (
+	// Call describes a single script invocation within a pipeline.
+	Call struct {
+		Keys []string
+		Args []any
+	}
+
+	// Pipeline represents a Redis client supporting pipelined EVALSHA calls,
+	// returning the result and error of each call in order.
+	Pipeline interface {
+		EvalShaPipeline(ctx context.Context, sha string, calls []Call) ([]any, []error)
+	}
+
+	// Batch queues rate-limiting tests, possibly across several limiters, to be
+	// executed together. Tests for limiters sharing a client which supports
+	// Pipeline are sent in a single round trip, bounded by the shortest timeout
+	// of those limiters; any test which fails within the pipeline is repeated
+	// individually, so that retries, failover and fallback apply as with
+	// Limiter.Test. The results of tests (but not peeks) are counted by Stats
+	// and reported to the hooks, logger and top denied keys as with
+	// Limiter.Test, but leasing, collapsing, asynchronous decisions and tracing
+	// are not applied to batches. The zero value is ready to use.
+	Batch struct {
+		ops []op
+	}
+
+	op struct {
+		limiter  *Limiter
+		key      string
+		settings *settings
+		keys     []string
+		all      []string
+		cost     float64
+		args     []any
+		opts     []any
+		readOnly bool
+		bypass   bool
+		record   bool
+	}
+)
+
+// Test queues a test as with Limiter.Test, returning its index in the results.
+func (b *Batch) Test(l *Limiter, key string, cost float64) int {
+	if cost == 0 {
+		// Calls with no cost only read the state, but are still recorded.
+		i := b.Peek(l, key, cost)
+		b.ops[i].record = true
+		return i
+	}
+	i := b.queue(l, key, cost, nil, false)
+	b.ops[i].record = true
+	return i
+}
+
+// Peek queues a test as with Limiter.Peek, returning its index in the results.
+func (b *Batch) Peek(l *Limiter, key string, cost float64) int {
+	return b.queue(l, key, cost, []any{"peek", 1}, true)
+}
+
+func (b *Batch) queue(l *Limiter, key string, cost float64, opts []any, readOnly bool) int {
+	k, s := l.shard(key)
+	keys := []string{k}
+	args, quota := l.quoted(l.clocked(opts))
+	b.ops = append(b.ops, op{l, key, s, keys, l.audited(nil, keys, 1, quota, l.metered()), cost, s.call(cost, args...), opts, readOnly, l.bypassed(key) || s.unlimited(), false})
+	return len(b.ops) - 1
+}
+
+// Exec executes all of the queued tests and returns their results in order,
+// after which the batch is empty. If any test fails, the first error is
+// returned, along with the results of the remaining tests.
+func (b *Batch) Exec(ctx context.Context) ([]Result, error) {
+	ops := b.ops
+	b.ops = nil
+
+	results := make([]Result, len(ops))
+	errs := make([]error, len(ops))
+
+	// Group the tests by client, so that each pipeline is a single round trip.
+	groups := map[Pipeline][]int{}
+	for i, o := range ops {
+		p, ok := as[Pipeline](o.limiter.redis)
+		switch {
+		case o.bypass:
+			results[i] = unlimited()
+		case ok && !o.limiter.noEvalSha && reflect.TypeOf(p).Comparable() && o.settings.check(o.cost) == nil:
+			groups[p] = append(groups[p], i)
+		default:
+			results[i], errs[i] = o.test(ctx)
+		}
+	}
+
+	for p, indices := range groups {
+		calls := make([]Call, len(indices))
+		for n, i := range indices {
+			o := ops[i]
+			if o.limiter.region != nil && !o.readOnly {
+				o.limiter.region.record(o.cost)
+			}
+			calls[n] = Call{o.all, o.args}
+		}
+		pctx, cancel := pipelineDeadline(ctx, ops, indices)
+		start := time.Now()
+		res, err := p.EvalShaPipeline(pctx, sha1, calls)
+		elapsed := time.Since(start)
+		cancel()
+
+		for n, i := range indices {
+			o := ops[i]
+			// A short reply is treated as a failure of the missing tests.
+			if n >= len(res) || n >= len(err) || err[n] != nil {
+				results[i], errs[i] = o.test(ctx)
+				continue
+			}
+			if o.limiter.metrics != nil {
+				o.limiter.metrics.Call(elapsed, nil)
+			}
+			o.limiter.health.ok()
+			results[i], errs[i] = o.limiter.result(o.key, o.settings, o.cost, res[n])
+		}
+	}
+
+	var first error
+	for i, o := range ops {
+		if o.record {
+			o.limiter.record(ctx, o.key, o.cost, results[i], errs[i])
+		}
+		if errs[i] != nil && first == nil {
+			first = errs[i]
+		}
+	}
+	return results, first
+}
+
+// Make the test individually, as with Limiter.Test.
+func (o op) test(ctx context.Context) (Result, error) {
+	return o.limiter.test(ctx, o.key, o.settings, o.keys, o.cost, o.readOnly, o.opts...)
+}
+
+// The pipeline is bounded by the shortest timeout of the limiters in it.
+func pipelineDeadline(ctx context.Context, ops []op, indices []int) (context.Context, context.CancelFunc) {
+	var timeout time.Duration
+	for _, i := range indices {
+		if t := ops[i].limiter.timeout; t > 0 && (timeout == 0 || t < timeout) {
+			timeout = t
+		}
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"math"
+)
+
+// Values with integer precision are in millionths of a unit.
+const microUnits = 1e6
+
+// WithIntegerPrecision performs all bucket math in integer millionths of a
+// unit and integer milliseconds, rather than in floating point, so that
+// arguments and replies need not be formatted and parsed as floats, and
+// long-lived keys do not accumulate rounding drift. Every flow and burst must
+// be at least one millionth. The state of keys is stored differently in this
+// mode, so it must not be enabled or disabled on keys which are in use, and it
+// cannot be changed by Reload. Audit entries likewise record the time in
+// milliseconds and the cost in millionths.
+func WithIntegerPrecision() Config {
+	return func(c *config) { c.integer = true }
+}
+
+// Convert the rate arguments to integer millionths.
+func integerArgs(args []any) ([]any, error) {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		v := int64(math.Round(arg.(float64) * microUnits))
+		if v <= 0 {
+			return nil, errors.New("limiter: rates are too small for integer precision")
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+// The number of ticks per second and of stored values per unit.
+func (s *settings) units() (int64, int64) {
+	if s.integer {
+		return 1000, microUnits
+	}
+	return 1, 1
+}
+
+// The rate of the given bucket, numbered from 0, in units.
+func (s *settings) rate(n int) Rate {
+	return Rate{unit(s.args[2*n]), unit(s.args[2*n+1])}
+}
+
+// Convert an argument to units, from integer millionths if necessary.
+func unit(arg any) float64 {
+	if i, ok := arg.(int64); ok {
+		return float64(i) / microUnits
+	}
+	return arg.(float64)
+}
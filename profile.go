@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	// Profile describes a reusable set of limits, such as for a plan tier.
+	Profile struct {
+		Bucket  Bucket
+		Configs []Config
+	}
+
+	// Profiles holds a limiter for each of a set of named profiles, and tests
+	// each key using the profile it is assigned.
+	Profiles struct {
+		limiters map[string]*Limiter
+		lookup   func(ctx context.Context, key string) (string, error)
+	}
+)
+
+// New creates a limiter from the profile, with any additional configuration.
+func (p Profile) New(redis Eval, configs ...Config) (*Limiter, error) {
+	return New(redis, p.Bucket, append(p.Configs[:len(p.Configs):len(p.Configs)], configs...)...)
+}
+
+// NewProfiles creates a limiter for each of the given named profiles, using
+// the lookup function to determine the profile of each key tested.
+func NewProfiles(redis Eval, profiles map[string]Profile, lookup func(ctx context.Context, key string) (string, error)) (*Profiles, error) {
+	if lookup == nil {
+		return nil, errors.New("limiter: profiles must have a lookup function")
+	}
+	p := &Profiles{limiters: make(map[string]*Limiter, len(profiles)), lookup: lookup}
+	for name, profile := range profiles {
+		l, err := profile.New(redis)
+		if err != nil {
+			return nil, errors.New("limiter: invalid profile " + name + ": " + err.Error())
+		}
+		p.limiters[name] = l
+	}
+	return p, nil
+}
+
+// Limiter returns the limiter for the named profile, if any.
+func (p *Profiles) Limiter(name string) (*Limiter, bool) {
+	l, ok := p.limiters[name]
+	return l, ok
+}
+
+// Test tests the given action using the limiter for the profile of the key.
+func (p *Profiles) Test(ctx context.Context, key string, cost float64) (Result, error) {
+	name, err := p.lookup(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	l, ok := p.limiters[name]
+	if !ok {
+		return Result{}, errors.New("limiter: unknown profile " + name)
+	}
+	return l.Test(ctx, key, cost)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// QuorumLimiter tests every key against several independent Redis instances,
+// and follows the decision of a majority of them, so that the loss of a
+// minority of the instances neither loses the limits nor fails the tests. This
+// suits deployments which cannot run Redis with replication; with three
+// instances, any one may be lost.
+type QuorumLimiter struct {
+	limiters []*Limiter
+}
+
+// NewQuorum creates a rate-limiter which tests every key against each of the
+// given Redis clients, each configured identically with the given parameters.
+func NewQuorum(clients []Eval, bucket Bucket, configs ...Config) (*QuorumLimiter, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("limiter: must have at least one redis client")
+	}
+
+	q := &QuorumLimiter{}
+	for _, client := range clients {
+		l, err := New(client, bucket, configs...)
+		if err != nil {
+			return nil, err
+		}
+		q.limiters = append(q.limiters, l)
+	}
+	return q, nil
+}
+
+// Test whether the given action should be allowed according to the rate limits
+// of a majority of the instances, which are tested concurrently. Those which
+// allow the action consume the cost even if the majority denies it. If the
+// majority allows it, the result reports the least remaining capacity and the
+// longest reset among them; otherwise, it is denied, and the wait is until
+// enough of the denying instances would allow it to form a majority. Only if
+// too many instances fail for a majority to reply are the errors returned.
+func (q *QuorumLimiter) Test(ctx context.Context, key string, cost float64) (Result, error) {
+	return q.do(func(l *Limiter) (Result, error) { return l.Test(ctx, key, cost) })
+}
+
+// Peek reports whether the given action would be allowed, as with Limiter.Peek,
+// according to a majority of the instances.
+func (q *QuorumLimiter) Peek(ctx context.Context, key string, cost float64) (Result, error) {
+	return q.do(func(l *Limiter) (Result, error) { return l.Peek(ctx, key, cost) })
+}
+
+func (q *QuorumLimiter) do(test func(*Limiter) (Result, error)) (Result, error) {
+	results := make([]Result, len(q.limiters))
+	errs := make([]error, len(q.limiters))
+	var wg sync.WaitGroup
+	for i, l := range q.limiters {
+		wg.Add(1)
+		go func(i int, l *Limiter) {
+			defer wg.Done()
+			results[i], errs[i] = test(l)
+		}(i, l)
+	}
+	wg.Wait()
+
+	var allows, denials []Result
+	for i, res := range results {
+		switch {
+		case errs[i] != nil:
+		case res.Allow:
+			allows = append(allows, res)
+		default:
+			denials = append(denials, res)
+		}
+	}
+
+	majority := len(q.limiters)/2 + 1
+	switch {
+	case len(allows) >= majority:
+		merged := allows[0]
+		for _, res := range allows[1:] {
+			merged.Free = min(merged.Free, res.Free)
+			merged.Reset = max(merged.Reset, res.Reset)
+		}
+		return merged, nil
+	case len(allows)+len(denials) < majority:
+		return Result{}, errors.Join(errs...)
+	}
+
+	// The action is allowed once enough of the denials have passed, which may
+	// be fewer than a majority of them if some instances allowed it.
+	sort.Slice(denials, func(i int, j int) bool { return denials[i].Wait < denials[j].Wait })
+	return denials[majority-len(allows)-1], nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+// Period describes the calendar window over which a quota or usage metering
+// applies, in UTC.
+type Period int
+
+const (
+	// Daily quotas reset at midnight.
+	Daily Period = iota + 1
+
+	// Weekly quotas reset at midnight on Monday.
+	Weekly
+
+	// Monthly quotas reset at midnight on the first of the month.
+	Monthly
+
+	// Hourly quotas reset on the hour.
+	Hourly
+)
+
+type quota struct {
+	limit  float64
+	period Period
+}
+
+// WithQuota allows at most the given total cost for each key within each
+// calendar period, in addition to the buckets, such as one million requests
+// per month. The quota is tracked as an absolute count for the current period,
+// in a record derived from the key (which is neither reported by Keys nor
+// deleted by ResetAll), rather than as a leaky bucket; once it is used, all
+// calls for that key are denied immediately until the period ends, and the
+// wait indicates the time remaining. The limit may be changed by Reload, but
+// the period may not.
+func WithQuota(limit float64, period Period) Config {
+	return func(c *config) { c.quota = quota{limit, period} }
+}
+
+func (q quota) args() ([]any, error) {
+	if q == (quota{}) {
+		return nil, nil
+	}
+	if q.limit <= 0 {
+		return nil, errors.New("limiter: quota must be positive")
+	}
+	if q.period < Daily || q.period > Hourly {
+		return nil, errors.New("limiter: invalid quota period")
+	}
+	return []any{"quota", q.limit}, nil
+}
+
+// The identifier and end of the window containing the given time.
+func (p Period) window(now time.Time) (string, time.Time) {
+	start := p.start(now)
+	switch p {
+	case Weekly:
+		return start.Format("2006-01-02"), start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.Format("2006-01"), start.AddDate(0, 1, 0)
+	case Hourly:
+		return start.Format("2006-01-02T15"), start.Add(time.Hour)
+	}
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+// The start of the window containing the given time.
+func (p Period) start(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	switch p {
+	case Weekly:
+		return start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	case Monthly:
+		return start.AddDate(0, 0, 1-d)
+	case Hourly:
+		return now.UTC().Truncate(time.Hour)
+	}
+	return start
+}
+
+// Append the end of the current quota window to the options of a call, if a
+// quota is configured, returning the kind of the records derived from the keys
+// to count its usage.
+func (l *Limiter) quoted(opts []any) ([]any, string) {
+	if l.quota == 0 {
+		return opts, ""
+	}
+	id, end := l.quota.window(l.now())
+	return append(opts[:len(opts):len(opts)], "quota_end", float64(end.Unix())), "quota:" + id
+}
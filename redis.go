@@ -6,11 +6,15 @@ package limiter
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"strings"
+	"sync/atomic"
 )
 
 type (
-	// Eval represents a Redis client supporting EVAL.
+	// Eval represents a Redis client supporting EVAL. For every method taking
+	// keys and arguments, these are only valid until the method returns, as
+	// they may be reused for later calls.
 	Eval interface {
 		Eval(ctx context.Context, script string, keys []string, args []any) (any, error)
 	}
@@ -19,20 +23,177 @@ type (
 	EvalSha interface {
 		EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error)
 	}
+
+	// EvalRO represents a Redis client supporting EVAL_RO.
+	EvalRO interface {
+		EvalRO(ctx context.Context, script string, keys []string, args []any) (any, error)
+	}
+
+	// EvalShaRO represents a Redis client supporting EVALSHA_RO.
+	EvalShaRO interface {
+		EvalShaRO(ctx context.Context, sha string, keys []string, args []any) (any, error)
+	}
+
+	// ScriptLoad represents a Redis client supporting SCRIPT LOAD.
+	ScriptLoad interface {
+		ScriptLoad(ctx context.Context, script string) (string, error)
+	}
+
+	// FCall represents a Redis client supporting FCALL.
+	FCall interface {
+		FCall(ctx context.Context, function string, keys []string, args []any) (any, error)
+	}
+
+	// FunctionLoad represents a Redis client supporting FUNCTION LOAD.
+	FunctionLoad interface {
+		FunctionLoad(ctx context.Context, code string) (string, error)
+	}
+
+	functions struct {
+		enabled  bool
+		disabled int32
+	}
 )
 
-//go:embed script/bucket.min.lua
+//go:embed script/bucket.lua
 var script string
 
-//go:embed script/bucket.min.lua.sha1
+//go:embed script/bucket.lua.sha1
 var sha1 string
 
-func exec(ctx context.Context, eval Eval, keys []string, args []any) (any, error) {
-	if evalsha, ok := eval.(EvalSha); ok {
+// The script is registered as a function in a library named for its hash, so
+// that multiple versions of this package can coexist on the same server.
+var (
+	function = "limiter_" + sha1
+	library  = "#!lua name=" + function + "\n" +
+		"redis.register_function('" + function + "', function(KEYS, ARGV)\n" +
+		strings.Replace(script, "redis.replicate_commands()", "", 1) +
+		"\nend)\n"
+)
+
+// WithFunctions registers the script as a Redis Function and invokes it using
+// FCALL, which requires a client supporting both FCall and FunctionLoad. If
+// the server does not support functions, EVAL and EVALSHA are used instead.
+func WithFunctions() Config {
+	return func(c *config) { c.functions = true }
+}
+
+// WithoutEvalSha always sends the full script using EVAL (or EVAL_RO), rather
+// than first attempting EVALSHA, for environments such as proxies where
+// EVALSHA is unsupported or misbehaves. This does not affect functions.
+func WithoutEvalSha() Config {
+	return func(c *config) { c.noEvalSha = true }
+}
+
+func (f *functions) validate(eval Eval) error {
+	if f.enabled {
+		if _, ok := as[FCall](eval); !ok {
+			return errors.New("limiter: functions require a client supporting FCALL")
+		}
+		if _, ok := as[FunctionLoad](eval); !ok {
+			return errors.New("limiter: functions require a client supporting FUNCTION LOAD")
+		}
+	}
+	return nil
+}
+
+// WithPreload loads the script when the limiter is created, as with Preload.
+func WithPreload() Config {
+	return func(c *config) { c.preload = true }
+}
+
+// Preload loads the script into Redis ahead of time, using SCRIPT LOAD (and
+// FUNCTION LOAD, if functions are enabled), so that calls need not fall back
+// to sending the full script. This may be called again whenever the script
+// may have been flushed, such as after a failover or when nodes are added.
+func (l *Limiter) Preload(ctx context.Context) error {
+	if l.functions.enabled && atomic.LoadInt32(&l.functions.disabled) == 0 {
+		loader, _ := as[FunctionLoad](l.redis)
+		_, err := loader.FunctionLoad(ctx, library)
+		switch {
+		case err == nil, strings.Contains(err.Error(), "already exists"):
+		case strings.Contains(strings.ToLower(err.Error()), "unknown command"):
+			atomic.StoreInt32(&l.functions.disabled, 1)
+		default:
+			return err
+		}
+	}
+
+	loader, ok := as[ScriptLoad](l.redis)
+	if !ok {
+		return errors.New("limiter: preloading requires a client supporting SCRIPT LOAD")
+	}
+	sha, err := loader.ScriptLoad(ctx, script)
+	if err != nil {
+		return err
+	}
+	if sha != sha1 {
+		return errors.New("limiter: loaded script hash does not match")
+	}
+	return nil
+}
+
+func (l *Limiter) exec(ctx context.Context, keys []string, args []any) (any, error) {
+	if l.functions.enabled && atomic.LoadInt32(&l.functions.disabled) == 0 {
+		fcall, _ := as[FCall](l.redis)
+		loader, _ := as[FunctionLoad](l.redis)
+		res, err := fcall.FCall(ctx, function, keys, args)
+		if err != nil && strings.Contains(err.Error(), "Function not found") {
+			if _, err = loader.FunctionLoad(ctx, library); err == nil {
+				res, err = fcall.FCall(ctx, function, keys, args)
+			}
+		}
+		if err == nil || !strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+			return res, err
+		}
+		// The server predates functions, so do not attempt them again.
+		atomic.StoreInt32(&l.functions.disabled, 1)
+	}
+	if evalsha, ok := as[EvalSha](l.redis); ok && !l.noEvalSha {
 		res, err := evalsha.EvalSha(ctx, sha1, keys, args)
 		if err == nil || !strings.Contains(err.Error(), "NOSCRIPT") {
 			return res, err
 		}
+		if l.nodes != nil && l.nodes.reload(ctx, keys[0]) {
+			res, err = evalsha.EvalSha(ctx, sha1, keys, args)
+			if err == nil || !strings.Contains(err.Error(), "NOSCRIPT") {
+				return res, err
+			}
+		}
+		l.stats.fallbacks.Add(1)
+		if l.metrics != nil {
+			l.metrics.Fallback()
+		}
+	}
+	return l.redis.Eval(ctx, script, keys, args)
+}
+
+// Read-only calls use EVAL_RO where supported, so they may be served by
+// replicas; otherwise they are executed normally.
+func (l *Limiter) execRO(ctx context.Context, keys []string, args []any) (any, error) {
+	evalro, ok := as[EvalRO](l.redis)
+	if !ok {
+		return l.exec(ctx, keys, args)
+	}
+	if evalsharo, ok := as[EvalShaRO](l.redis); ok && !l.noEvalSha {
+		res, err := evalsharo.EvalShaRO(ctx, sha1, keys, args)
+		if err == nil || !strings.Contains(err.Error(), "NOSCRIPT") {
+			return res, err
+		}
+	}
+	return evalro.EvalRO(ctx, script, keys, args)
+}
+
+// The client as the given optional interface, if it implements it, or if any
+// client it wraps (such as that of NewScripter) does.
+func as[T any](eval Eval) (T, bool) {
+	if t, ok := eval.(T); ok {
+		return t, true
+	}
+	if w, ok := eval.(interface{ unwrap() any }); ok {
+		t, ok := w.unwrap().(T)
+		return t, ok
 	}
-	return eval.Eval(ctx, script, keys, args)
+	var zero T
+	return zero, false
 }
@@ -0,0 +1,181 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Enforces a share of a global limit, which is split between regions that
+	// each have their own Redis.
+	region struct {
+		name    string
+		share   float64
+		current atomic.Uint64
+		demand  atomic.Int64
+		scaled  atomic.Pointer[regionSettings]
+	}
+
+	// The settings scaled by the share for which they were last computed.
+	regionSettings struct {
+		base     *settings
+		share    float64
+		settings *settings
+	}
+)
+
+const (
+	regionsRecord = `redis.call('hset', KEYS[1], ARGV[1], ARGV[2])
+redis.call('pexpire', KEYS[1], ARGV[3])
+return redis.call('hgetall', KEYS[1])`
+	regionsLoad = `return redis.call('hgetall', KEYS[1])`
+)
+
+// WithRegion enforces the given share (between 0 and 1) of the buckets in the
+// named region, for a global limit which is split between regions that each
+// have their own Redis, as a single bucket cannot be shared synchronously
+// between them. The shares of every region should total 1. The share may then
+// be adjusted to the demand of each region using WatchRegions.
+func WithRegion(name string, share float64) Config {
+	return func(c *config) {
+		c.region = &region{name: name, share: share}
+		c.region.current.Store(math.Float64bits(share))
+	}
+}
+
+func (r *region) validate() error {
+	if r != nil && (r.name == "" || r.share <= 0 || r.share > 1) {
+		return errors.New("limiter: region must have a name and a share between 0 and 1")
+	}
+	return nil
+}
+
+// Count the cost tested towards the demand of the region.
+func (r *region) record(cost float64) {
+	r.demand.Add(int64(math.Round(cost * microUnits)))
+}
+
+// The settings scaled by the current share of the region, which are computed
+// again only if either has changed.
+func (r *region) scale(s *settings) *settings {
+	share := math.Float64frombits(r.current.Load())
+	if c := r.scaled.Load(); c != nil && c.base == s && c.share == share {
+		return c.settings
+	}
+	c := &regionSettings{s, share, s.shared(share)}
+	r.scaled.Store(c)
+	return c.settings
+}
+
+// A copy of the settings with the rates multiplied by the given share.
+func (s *settings) shared(share float64) *settings {
+	c := *s
+	c.args = append([]any(nil), s.args...)
+	for i, arg := range c.args[:s.rates] {
+		switch v := arg.(type) {
+		case int64:
+			c.args[i] = max(1, int64(math.Round(float64(v)*share)))
+		case float64:
+			c.args[i] = v * share
+		}
+	}
+	c.wire = encode(c.args)
+	return &c
+}
+
+// WatchRegions adjusts the share of the region configured using WithRegion to
+// its demand, at the given interval until the context is done. Each time, the
+// cost tested per second since the previous adjustment is recorded under the
+// name of the region in the given hash in Redis, and the demand of the other
+// regions is read from the same hash in each of the given clients for the
+// Redis of those regions. The share then becomes the average of the configured
+// share and the fraction of the total demand, so that the shares still total 1
+// while each region keeps at least half of its configured share. If any region
+// cannot be reached, the share is left unchanged. Failed adjustments are
+// logged at the error level, if a logger has been configured.
+func (l *Limiter) WatchRegions(ctx context.Context, interval time.Duration, hash string, peers ...Eval) error {
+	if l.region == nil {
+		return errors.New("limiter: watching regions requires a region")
+	}
+	if interval <= 0 {
+		return errors.New("limiter: region interval must be positive")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if err := l.reconcile(ctx, interval, hash, peers); err != nil && l.logger != nil && ctx.Err() == nil {
+				l.logger.LogAttrs(ctx, slog.LevelError, "region reconciliation failed",
+					slog.String("hash", hash), slog.Any("error", err))
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *Limiter) reconcile(ctx context.Context, interval time.Duration, hash string, peers []Eval) error {
+	r := l.region
+	rate := float64(r.demand.Swap(0)) / microUnits / interval.Seconds()
+
+	// The demand of this region expires if it stops recording it.
+	demands := map[string]float64{}
+	raw, err := l.redis.Eval(ctx, regionsRecord, []string{hash},
+		[]any{r.name, strconv.FormatFloat(rate, 'g', -1, 64), (3 * interval).Milliseconds()})
+	if err != nil {
+		return err
+	}
+	if err := parseDemands(raw, demands); err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		raw, err := peer.Eval(ctx, regionsLoad, []string{hash}, nil)
+		if err != nil {
+			return err
+		}
+		if err := parseDemands(raw, demands); err != nil {
+			return err
+		}
+	}
+
+	total := 0.0
+	for _, demand := range demands {
+		total += demand
+	}
+	share := r.share
+	if total > 0 {
+		share = (r.share + demands[r.name]/total) / 2
+	}
+	r.current.Store(math.Float64bits(share))
+	return nil
+}
+
+// Add the demand of each region from a reply to HGETALL.
+func parseDemands(raw any, demands map[string]float64) error {
+	res, ok := raw.([]any)
+	if !ok || len(res)%2 != 0 {
+		return errInvalidReply
+	}
+	for i := 0; i < len(res); i += 2 {
+		name, ok1 := res[i].(string)
+		demand, ok2 := parseFloat(res[i+1])
+		if !ok1 || !ok2 {
+			return errInvalidReply
+		}
+		demands[name] = demand
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// Registry lazily constructs and caches a limiter per tenant, so that
+	// tenants may have differing limits (such as by plan) without every
+	// limiter being constructed up front.
+	Registry struct {
+		provider func(tenant string) (*Limiter, error)
+		size     int
+		ttl      time.Duration
+
+		mu      sync.Mutex
+		entries map[string]*list.Element
+		lru     *list.List
+	}
+
+	entry struct {
+		tenant  string
+		limiter *Limiter
+		created time.Time
+	}
+)
+
+// NewRegistry creates a registry which constructs limiters using the given
+// provider, keeping at most the given number of them, evicting the least
+// recently used first. If the TTL is positive, limiters are also constructed
+// again once they are older than it, so that changes to a tenant's limits
+// are picked up. Errors from the provider are not cached.
+func NewRegistry(provider func(tenant string) (*Limiter, error), size int, ttl time.Duration) (*Registry, error) {
+	if provider == nil {
+		return nil, errors.New("limiter: registry must have a provider")
+	}
+	if size <= 0 {
+		return nil, errors.New("limiter: registry size must be positive")
+	}
+	if ttl < 0 {
+		return nil, errors.New("limiter: registry TTL must be positive")
+	}
+	return &Registry{provider: provider, size: size, ttl: ttl, entries: map[string]*list.Element{}, lru: list.New()}, nil
+}
+
+// Get returns the limiter for the given tenant, constructing it if necessary.
+func (r *Registry) Get(tenant string) (*Limiter, error) {
+	if l := r.lookup(tenant, time.Now()); l != nil {
+		return l, nil
+	}
+
+	// The provider is called without holding the lock, as it may be slow; if
+	// several callers race, the first limiter to be stored is used.
+	l, err := r.provider(tenant)
+	if err != nil {
+		return nil, err
+	}
+	return r.store(tenant, l, time.Now()), nil
+}
+
+// Test tests the given action using the limiter for the given tenant.
+func (r *Registry) Test(ctx context.Context, tenant string, key string, cost float64) (Result, error) {
+	l, err := r.Get(tenant)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.Test(ctx, key, cost)
+}
+
+// Len returns the number of limiters currently cached.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lru.Len()
+}
+
+func (r *Registry) lookup(tenant string, now time.Time) *Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, ok := r.entries[tenant]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*entry)
+	if r.ttl > 0 && now.Sub(e.created) >= r.ttl {
+		r.lru.Remove(el)
+		delete(r.entries, tenant)
+		return nil
+	}
+	r.lru.MoveToFront(el)
+	return e.limiter
+}
+
+func (r *Registry) store(tenant string, l *Limiter, now time.Time) *Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if el, ok := r.entries[tenant]; ok {
+		r.lru.MoveToFront(el)
+		return el.Value.(*entry).limiter
+	}
+	r.entries[tenant] = r.lru.PushFront(&entry{tenant, l, now})
+	for r.lru.Len() > r.size {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*entry).tenant)
+	}
+	return l
+}
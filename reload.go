@@ -0,0 +1,193 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+// settings holds the configuration which may be replaced while the limiter is
+// in use; each call uses a single snapshot throughout.
+type settings struct {
+	args     []any
+	wire     []any
+	rates    int
+	backoff  func(float64) float64
+	byRate   map[Rate]func(float64) float64
+	backoffs []func(float64) float64
+	integer  bool
+	jitter   float64
+	maxWait  time.Duration
+	schedule schedule
+}
+
+// Build the script arguments and backoff from the configuration.
+func (c *config) settings() (*settings, error) {
+	args, err := c.rateArgs(c.rates)
+	if err != nil {
+		return nil, err
+	}
+	rates := len(args)
+
+	// Append any optional features as name and value pairs after the rates.
+	for _, opt := range []option{c.penalty, c.warmup, c.dedup, c.audit, c.weights, c.banning, c.rollover, c.quota, c.meter, c.fair, c.notify, c.replica} {
+		opts, err := opt.args()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, opts...)
+	}
+	if c.integer {
+		args = append(args, "integer", 1)
+	}
+
+	// The backoff is only absent if the exact wait is used instead.
+	if c.backoff == nil && !c.exact {
+		return nil, errors.New("limiter: must have a backoff")
+	}
+	s := &settings{
+		args:     args,
+		wire:     encode(args),
+		rates:    rates,
+		backoff:  c.backoff,
+		byRate:   c.backoffs,
+		backoffs: resolveBackoffs(c.rates, c.backoff, c.backoffs),
+		integer:  c.integer,
+		jitter:   c.jitter,
+		maxWait:  c.maxWait,
+	}
+
+	// Each window of the schedule has its own buckets, with the same options.
+	if len(c.schedule.windows) > 0 {
+		s.schedule = schedule{c.schedule.location, make([]window, len(c.schedule.windows))}
+		for i, w := range c.schedule.windows {
+			rates, err := c.rateArgs(w.rates)
+			if err != nil {
+				return nil, err
+			}
+			ws := *s
+			ws.args = append(rates, args[s.rates:]...)
+			ws.wire = encode(ws.args)
+			ws.rates = len(rates)
+			ws.backoffs = resolveBackoffs(w.rates, c.backoff, c.backoffs)
+			ws.schedule = schedule{}
+			w.settings = &ws
+			s.schedule.windows[i] = w
+		}
+	}
+	return s, nil
+}
+
+// Turn the rates into script arguments, with integer precision if configured.
+func (c *config) rateArgs(rates []Rate) ([]any, error) {
+	args, err := rateArgs(rates)
+	if err != nil || !c.integer {
+		return args, err
+	}
+	return integerArgs(args)
+}
+
+// Whether every bucket is unlimited, so that no call need be made.
+func (s *settings) unlimited() bool {
+	return s.rates == 0
+}
+
+// Build the script arguments for a single call.
+func (s *settings) call(cost float64, opts ...any) []any {
+	return s.appendCall(make([]any, 0, len(s.wire)+len(opts)+1), cost, opts...)
+}
+
+// Append the script arguments for a single call to the given slice.
+func (s *settings) appendCall(args []any, cost float64, opts ...any) []any {
+	args = append(args, s.cost(cost))
+	args = append(args, s.wire...)
+	return append(args, opts...)
+}
+
+// Reload validates and applies the given buckets and configuration, as with
+// New. The buckets, schedule, backoff (including any jitter and maximum wait),
+// penalty box, warm-up, banning, rollover, quota limit, metering retention,
+// fair sharing window, notification channel and audit stream are replaced
+// atomically, so that tests in progress use either the previous or the new
+// configuration throughout; any other configuration is fixed when the limiter
+// is created, and is ignored. If the configuration is invalid, the limiter is
+// left unchanged.
+func (l *Limiter) Reload(bucket Bucket, configs ...Config) error {
+	c := newConfig(bucket, configs)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if c.audit.stream != l.audit {
+		return errors.New("limiter: the audit stream cannot be changed")
+	}
+	if c.weights != l.weights {
+		return errors.New("limiter: the weights hash cannot be changed")
+	}
+	if c.fair.set != l.fair {
+		return errors.New("limiter: the fair sharing set cannot be changed")
+	}
+	if (c.banning != banning{}) != l.banning {
+		return errors.New("limiter: banning cannot be enabled or disabled")
+	}
+	if c.replica != l.replica {
+		return errors.New("limiter: the replica name cannot be changed")
+	}
+	if c.quota.period != l.quota {
+		return errors.New("limiter: the quota period cannot be changed")
+	}
+	if c.meter.period != l.meter {
+		return errors.New("limiter: the metering period cannot be changed")
+	}
+	if c.integer != l.settings.Load().integer {
+		return errors.New("limiter: integer precision cannot be changed")
+	}
+
+	s, err := c.settings()
+	if err != nil {
+		return err
+	}
+	l.settings.Store(s)
+	l.warn(c)
+	return nil
+}
+
+// Replace the rates, in every window of any schedule, keeping the remaining
+// settings.
+func (l *Limiter) setRates(rates []Rate) error {
+	args, err := rateArgs(rates)
+	if err != nil {
+		return err
+	}
+	// Integer precision cannot be changed, so it need not be checked again.
+	if l.settings.Load().integer {
+		if args, err = integerArgs(args); err != nil {
+			return err
+		}
+	}
+	for {
+		old := l.settings.Load()
+		s := *old
+		s.args = append(args[:len(args):len(args)], old.args[old.rates:]...)
+		s.wire = encode(s.args)
+		s.rates = len(args)
+		s.backoffs = resolveBackoffs(rates, old.backoff, old.byRate)
+		s.schedule = schedule{}
+
+		// The rates apply in every window too, so that none keeps enforcing
+		// the previous buckets.
+		if len(old.schedule.windows) > 0 {
+			windows := make([]window, len(old.schedule.windows))
+			for i, w := range old.schedule.windows {
+				ws := s
+				w.rates, w.settings = rates, &ws
+				windows[i] = w
+			}
+			s.schedule = schedule{old.schedule.location, windows}
+		}
+		if l.settings.CompareAndSwap(old, &s) {
+			return nil
+		}
+	}
+}
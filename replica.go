@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "errors"
+
+type replica string
+
+// WithActiveActive stores the state of each key as a hash with a field for each
+// replica of an active-active (CRDT) database, such as Redis Enterprise, in
+// which only the named replica writes its own field. The levels of every
+// replica are drained and summed when the key is tested, so that consumption
+// is neither lost nor counted twice when concurrent writes are merged, as they
+// would be by last-write-wins for a single value. Each replica must have a
+// distinct name. Denials and the penalty box are counted separately by each
+// replica. Keys, State, Export, Import and TopDenied do not support this
+// format.
+func WithActiveActive(name string) Config {
+	return func(c *config) { c.replica = replica(name) }
+}
+
+func (r replica) args() ([]any, error) {
+	if r == "" {
+		return nil, nil
+	}
+	// The script distinguishes options from buckets by whether they are numbers.
+	if numeric(string(r)) {
+		return nil, errors.New("limiter: replica name must not be a number")
+	}
+	return []any{"replica", string(r)}, nil
+}
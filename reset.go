@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+// The maximum number of keys which may be deleted by a single ResetAll, as a
+// safeguard against patterns which match far more than intended.
+const resetLimit = 10000
+
+const resetDelete = `return redis.call('del', unpack(KEYS))`
+
+// ResetAll deletes the state of every key matching the given glob pattern, as
+// passed to Test (without any prefix), so that callers charged incorrectly may
+// be restored to full capacity. Keys are found by scanning and deleted in
+// batches; no more than 10000 keys are deleted, after which an error is
+// returned. The number of keys deleted is returned even if an error occurs.
+// This requires a client supporting Scan.
+func (l *Limiter) ResetAll(ctx context.Context, pattern string) (int, error) {
+	if pattern == "" {
+		return 0, errors.New("limiter: must have a pattern")
+	}
+
+	deleted := 0
+	err := l.scan(ctx, pattern, func(keys []scanned) error {
+		if deleted+len(keys) > resetLimit {
+			return errors.New("limiter: reset limit reached")
+		}
+		prefixed := make([]string, len(keys))
+		for i, key := range keys {
+			prefixed[i] = key.stored
+		}
+
+		// Keys in a cluster may belong to different slots, so each is deleted
+		// individually.
+		batches := [][]string{prefixed}
+		if l.cluster {
+			batches = batches[:0]
+			for i := range prefixed {
+				batches = append(batches, prefixed[i:i+1])
+			}
+		}
+		for _, batch := range batches {
+			raw, err := l.redis.Eval(ctx, resetDelete, batch, nil)
+			if err != nil {
+				return err
+			}
+			n, ok := raw.(int64)
+			if !ok {
+				return errInvalidReply
+			}
+			deleted += int(n)
+		}
+		return nil
+	})
+	return deleted, err
+}
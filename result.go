@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// String formats the result for logging, omitting fields which do not apply.
+func (r Result) String() string {
+	if r.Allow {
+		return fmt.Sprintf("allow (free %g, reset %v, bucket %d)", r.Free, r.Reset, r.Bucket)
+	}
+	return fmt.Sprintf("deny (wait %v, reset %v, bucket %d)", r.Wait, r.Reset, r.Bucket)
+}
+
+// MarshalJSON encodes the result for structured responses, with the wait and
+// reset in seconds, and the time at which the cost would be allowed, if known.
+// Infinite remaining capacity, as for bypassed keys and unlimited buckets, is
+// encoded as null, as JSON has no infinite numbers.
+func (r Result) MarshalJSON() ([]byte, error) {
+	res := struct {
+		Allow   bool       `json:"allow"`
+		Free    *float64   `json:"free"`
+		Wait    float64    `json:"wait"`
+		Reset   float64    `json:"reset"`
+		RetryAt *time.Time `json:"retryAt,omitempty"`
+		Bucket  int        `json:"bucket"`
+	}{r.Allow, &r.Free, r.Wait.Seconds(), r.Reset.Seconds(), nil, r.Bucket}
+	if math.IsInf(r.Free, 0) {
+		res.Free = nil
+	}
+	if !r.RetryAt.IsZero() {
+		res.RetryAt = &r.RetryAt
+	}
+	return json.Marshal(res)
+}
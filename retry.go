@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+type retry struct {
+	attempts int
+	delay    time.Duration
+}
+
+// The delay stops doubling once it reaches a minute, or the configured delay if
+// that is longer.
+const maxRetryDelay = time.Minute
+
+// WithRetry retries calls which fail with clearly transient errors, such as
+// connection resets, up to the given number of additional attempts. Each
+// attempt waits for a random duration of up to the given delay, doubling for
+// every subsequent attempt up to a minute, to avoid synchronized retries.
+func WithRetry(attempts int, delay time.Duration) Config {
+	return func(c *config) { c.retry = retry{attempts, delay} }
+}
+
+func (r retry) validate() error {
+	if r.attempts < 0 || r.delay < 0 || (r.attempts > 0 && r.delay == 0) {
+		return errors.New("limiter: retry parameters must be positive")
+	}
+	return nil
+}
+
+func (l *Limiter) retry(ctx context.Context, exec func() (any, error)) (any, error) {
+	res, err := exec()
+	for i := 0; i < l.retries.attempts && err != nil && IsTransient(err); i++ {
+		l.health.set(HealthRetrying, err)
+		if err := sleep(ctx, time.Duration(rand.Int63n(int64(l.retries.backoff(i)))+1)); err != nil {
+			return nil, err
+		}
+		res, err = exec()
+	}
+	return res, err
+}
+
+// The delay for the given attempt, which stops doubling at the limit so that
+// it cannot overflow.
+func (r retry) backoff(attempt int) time.Duration {
+	limit := max(r.delay, maxRetryDelay)
+	delay := max(r.delay, 1)
+	for n := 0; n < attempt && delay < limit; n++ {
+		delay = min(2*delay, limit)
+	}
+	return delay
+}
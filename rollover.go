@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "errors"
+
+type rollover struct {
+	fraction float64
+	max      float64
+}
+
+// WithRollover keeps the given fraction of any capacity of the slowest bucket
+// which goes unused, as a credit of up to the given maximum, which extends the
+// burst of that bucket until it is spent; as with "rollover minutes", capacity
+// left unused in one window is partly available in the next. Keys are kept for
+// long enough to accrue the maximum credit.
+func WithRollover(fraction float64, max float64) Config {
+	return func(c *config) { c.rollover = rollover{fraction, max} }
+}
+
+func (r rollover) args() ([]any, error) {
+	if r.fraction == 0 && r.max == 0 {
+		return nil, nil
+	}
+	if r.fraction <= 0 || r.fraction > 1 || r.max <= 0 {
+		return nil, errors.New("limiter: rollover must keep a fraction within (0, 1] up to a positive maximum")
+	}
+	return []any{"rollover", r.fraction, "rollover_cap", r.max}, nil
+}
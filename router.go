@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"math"
+)
+
+// Router maps routes (or operation names) to the limiters which apply to them,
+// so that a whole set of limits can be defined in one place.
+type Router struct {
+	routes map[string]*Limiter
+	def    *Limiter
+}
+
+// NewRouter creates a router using the given limiters for each route, and the
+// default limiter for any other route. A nil limiter (including the default)
+// leaves its routes unlimited. Routes sharing a limiter also share the limits
+// of each key, unless their keys are distinguished by the caller.
+func NewRouter(def *Limiter, routes map[string]*Limiter) *Router {
+	r := &Router{routes: make(map[string]*Limiter, len(routes)), def: def}
+	for route, l := range routes {
+		r.routes[route] = l
+	}
+	return r
+}
+
+// Limiter returns the limiter which applies to the given route, if any.
+func (r *Router) Limiter(route string) *Limiter {
+	if l, ok := r.routes[route]; ok {
+		return l
+	}
+	return r.def
+}
+
+// Test tests the given action using the limiter for the given route. Actions
+// on unlimited routes are always allowed, with infinite remaining capacity.
+func (r *Router) Test(ctx context.Context, route string, key string, cost float64) (Result, error) {
+	l := r.Limiter(route)
+	if l == nil {
+		return Result{Allow: true, Free: math.Inf(1)}, nil
+	}
+	return l.Test(ctx, key, cost)
+}
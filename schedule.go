@@ -0,0 +1,122 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// Window describes buckets which apply in place of the default buckets
+	// during a recurring time of day, such as business hours.
+	Window struct {
+		// Days are the days of the week on which the window starts; if empty,
+		// it starts on every day.
+		Days []time.Weekday
+
+		// Start and End are the times of day, as offsets from midnight, at
+		// which the window starts and ends. A window which ends at or before
+		// its start continues past midnight into the following day.
+		Start time.Duration
+		End   time.Duration
+
+		// Buckets are the buckets which apply during the window.
+		Buckets []Bucket
+	}
+
+	schedule struct {
+		location *time.Location
+		windows  []window
+	}
+
+	window struct {
+		Window
+		rates    []Rate
+		settings *settings
+	}
+)
+
+// WithSchedule applies different buckets during the given windows, in the
+// given time zone (or UTC if nil), such as to allow more capacity on nights
+// and weekends. The first window containing the current time applies, or the
+// default buckets if there is none. Keys carry their bucket levels across a
+// change of window by position, from slowest to fastest flow. All other
+// configuration applies in every window.
+func WithSchedule(location *time.Location, windows ...Window) Config {
+	return func(c *config) {
+		if location == nil {
+			location = time.UTC
+		}
+		c.schedule = schedule{location: location}
+		for _, w := range windows {
+			if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End > 24*time.Hour {
+				c.err = errors.New("limiter: window times must be within a day")
+				return
+			}
+			if len(w.Buckets) == 0 {
+				c.err = errors.New("limiter: window must have at least one bucket")
+				return
+			}
+			s := window{Window: w}
+			for _, bucket := range w.Buckets {
+				if v, ok := bucket.(interface{ validate() error }); ok && c.err == nil {
+					c.err = v.validate()
+				}
+				flow, burst := bucket.Rate()
+				s.rates = append(s.rates, Rate{flow, burst})
+			}
+			c.schedule.windows = append(c.schedule.windows, s)
+		}
+	}
+}
+
+// Whether the window contains the given time, in the time zone of the schedule.
+func (w *window) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	offset := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End && w.on(t.Weekday())
+	}
+	return (offset >= w.Start && w.on(t.Weekday())) || (offset < w.End && w.on((t.Weekday()+6)%7))
+}
+
+func (w *window) on(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// The settings which apply at the given time, according to the schedule.
+func (s *settings) active(now time.Time) *settings {
+	if len(s.schedule.windows) == 0 {
+		return s
+	}
+	now = now.In(s.schedule.location)
+	for i := range s.schedule.windows {
+		if w := &s.schedule.windows[i]; w.contains(now) {
+			return w.settings
+		}
+	}
+	return s
+}
+
+// The settings which currently apply, according to the clock if any, and
+// scaled by the share of the region if any.
+func (l *Limiter) current() *settings {
+	s := l.settings.Load()
+	if len(s.schedule.windows) > 0 {
+		s = s.active(l.now())
+	}
+	if l.region != nil {
+		s = l.region.scale(s)
+	}
+	return s
+}
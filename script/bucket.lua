@@ -0,0 +1,504 @@
+-- Leaky-bucket rate limiter.
+--
+-- KEYS[1..] The keys holding the bucket states; the cost is only consumed
+--           from these if every one of them allows it.
+-- KEYS[#]   The key holding a prior result for the request ID, if the dedup
+--           option is given.
+-- KEYS[#]   The keys holding the ban records of each of the bucket keys, in the
+--           same order, if the ban option is given.
+-- KEYS[#]   The keys counting the usage of each of the bucket keys within the
+--           current quota window, in the same order, if the quota option is
+--           given.
+-- KEYS[#]   The hashes counting the usage of each of the bucket keys within the
+--           current metering window, in the same order, if the meter option
+--           is given.
+-- KEYS[#]   The hash holding a weight by which the cost is multiplied for each
+--           key, if the weights option is given; keys without a weight have
+--           a weight of 1.
+-- KEYS[#]   The sorted set of keys which have recently been active, by the time
+--           each was last tested, and the sorted set of their recent demand,
+--           if the fair option is given.
+-- KEYS[#]   The stream to which denials are appended, if the audit option is
+--           given; this follows any other keys.
+-- ARGV[1]   The cost of the action being tested.
+-- ARGV[2..] Flow and burst pairs for each bucket, ordered from slowest to
+--           fastest flow, followed by optional name and value pairs. With
+--           the peek option, no state is written and the script may be
+--           invoked using EVAL_RO. With the now option, the given time (in
+--           seconds) is used rather than the time of the server. With the
+--           integer option, the cost, flows and bursts are integers in
+--           millionths of a unit, and all bucket math is done in integers
+--           of those and of milliseconds. With the ban option, denials are
+--           accumulated in a bucket draining at the given rate, and a key is
+--           banned for ban_ttl seconds once they exceed ban_period seconds
+--           of that rate. With the rollover option, the given fraction
+--           of any capacity of the slowest bucket which goes unused is
+--           kept as a credit, up to rollover_cap, extending its burst.
+--           With the quota option, at most the given total cost is allowed
+--           for each key until quota_end (in seconds), when the current
+--           quota window ends. With the fair option, the flows and bursts
+--           are divided max-min fairly between the keys active within the
+--           last fair seconds, by their demand over that time. With the
+--           settle option, the cost (which may be
+--           negative) adjusts the levels and any quota and metered usage
+--           already charged, and is never denied, with no level falling
+--           below empty. With the meter option, the allowed and denied
+--           cost of each key is counted in its metering hash, which expires
+--           after the given number of seconds. With the replica option, each key is a hash
+--           of the states of each replica of an active-active database,
+--           of which only the given replica's field is written, and the
+--           levels of the others are added to its own. With the notify
+--           option, "denied:" or "allowed:" followed by the key is
+--           published on the given channel whenever a key is denied after
+--           being allowed, or allowed after being denied, by the buckets.
+--
+-- Returns {allow, value, index, drain, fit}, where value is the remaining
+-- capacity if allowed or the accumulated denied cost otherwise, and index is
+-- the bucket which was most restrictive. An index of 0 indicates that the key
+-- is in the penalty box, in which case value is the remaining penalty in
+-- seconds. Drain is the time in seconds until every bucket is empty, and fit
+-- is the time in seconds until the cost would be allowed. These are replied as
+-- integers; value is in millionths (of a unit, or of a second for the
+-- penalty), and drain and fit are in milliseconds.
+redis.replicate_commands()
+
+local cost = tonumber(ARGV[1])
+
+local flows, bursts, opts = {}, {}, {}
+for i = 2, #ARGV, 2 do
+  local flow = tonumber(ARGV[i])
+  if flow then
+    flows[#flows + 1], bursts[#bursts + 1] = flow, tonumber(ARGV[i + 1])
+  else
+    opts[ARGV[i]] = ARGV[i + 1]
+  end
+end
+
+-- Times are kept in ticks, which are milliseconds with the integer option.
+local integer = opts.integer
+local tick = integer and 1000 or 1
+
+-- The time in ticks for the given amount to drain at the given flow.
+local function span(amount, flow)
+  if integer then
+    return math.ceil(amount * 1000 / flow)
+  end
+  return amount / flow
+end
+
+-- Values are replied as integers in millionths, and times in milliseconds, so
+-- that the replies need not be parsed from strings.
+local function out(value)
+  if integer then
+    return value
+  end
+  return math.floor(value * 1000000 + 0.5)
+end
+
+local function ms(time)
+  if integer then
+    return time
+  end
+  return math.ceil(time * 1000)
+end
+
+local keys, dedup, weights, active, demand, stream, bans, quotas, meters =
+  {unpack(KEYS)}, nil, nil, nil, nil, nil, {}, {}, {}
+
+if opts.audit then
+  stream = table.remove(keys)
+end
+if opts.fair then
+  demand = table.remove(keys)
+  active = table.remove(keys)
+end
+if opts.weights then
+  weights = table.remove(keys)
+end
+local limited = (#keys - (opts.dedup and 1 or 0)) /
+  (1 + (opts.ban and 1 or 0) + (opts.quota and 1 or 0) + (opts.meter and 1 or 0))
+if opts.meter then
+  for k = limited, 1, -1 do
+    meters[k] = table.remove(keys)
+  end
+end
+if opts.quota then
+  for k = limited, 1, -1 do
+    quotas[k] = table.remove(keys)
+  end
+end
+if opts.ban then
+  for k = limited, 1, -1 do
+    bans[k] = table.remove(keys)
+  end
+end
+
+-- Requests which have already been allowed are not charged again.
+if opts.dedup then
+  dedup = table.remove(keys)
+  local prior = redis.call('get', dedup)
+  if prior then
+    local free, index = cmsgpack.unpack(prior)
+    return {1, out(free), index, ms(0), ms(0)}
+  end
+end
+
+-- The current time may be supplied by the caller, rather than by the server.
+local now = tonumber(opts.now)
+if not now then
+  local time = redis.call('time')
+  now = tonumber(time[1]) + tonumber(time[2]) / 1e6
+end
+
+local ramp, start = tonumber(opts.warmup_ttl), tonumber(opts.warmup)
+local threshold, duration = tonumber(opts.penalty), tonumber(opts.penalty_ttl)
+local banFlow, banTTL = tonumber(opts.ban), tonumber(opts.ban_ttl)
+if integer then
+  now = math.floor(now * 1000)
+  ramp = ramp and ramp * 1000
+  duration = duration and math.ceil(duration * 1000)
+  banFlow = banFlow and banFlow * 1e6
+end
+local banLimit = banFlow and banFlow * tonumber(opts.ban_period)
+
+-- The amount drained at the given flow since the given time in ticks. With the
+-- integer option, this is the difference between the whole units drained from
+-- the epoch until each time, so that the fraction left over by one call is
+-- drained by the next rather than lost; the seconds and milliseconds are
+-- split so that the products stay exact.
+local function drained(last, flow)
+  if last >= now then
+    return 0
+  end
+  if integer then
+    return (math.floor(now / 1000) - math.floor(last / 1000)) * flow +
+      math.floor(now % 1000 * flow / 1000) - math.floor(last % 1000 * flow / 1000)
+  end
+  return (now - last) * flow
+end
+local rollover, rolloverCap = tonumber(opts.rollover), tonumber(opts.rollover_cap)
+if integer and rolloverCap then
+  rolloverCap = rolloverCap * 1e6
+end
+local quota, quotaEnd = tonumber(opts.quota), tonumber(opts.quota_end)
+if quota and integer then
+  quota, quotaEnd = quota * 1e6, math.ceil(quotaEnd * 1000)
+end
+
+-- The rates are shared max-min fairly between the keys active within the
+-- window: the demand of each other key is the cost it has tested, decaying
+-- over the window, and those demanding less than an equal share leave the
+-- remainder to be shared between the rest, including the keys being tested.
+-- A key which is alone, or whose peers demand little, may use the whole rate.
+if active then
+  local window = tonumber(opts.fair)
+  local since = now - window * tick
+
+  -- The demand of a key decays from the time it was last tested.
+  local function decayed(key, last)
+    if not last then
+      return 0
+    end
+    local d = tonumber(redis.call('zscore', demand, key)) or 0
+    return d * math.exp(-(now - last) / (window * tick))
+  end
+
+  if not opts.peek then
+    for _, key in ipairs(keys) do
+      local last = tonumber(redis.call('zscore', active, key))
+      if last and last < since then
+        last = nil
+      end
+      redis.call('zadd', demand, decayed(key, last) + cost, key)
+      redis.call('zadd', active, now, key)
+    end
+    local stale = redis.call('zrangebyscore', active, '-inf', '(' .. since)
+    for i = 1, #stale, 1000 do
+      redis.call('zrem', demand, unpack(stale, i, math.min(i + 999, #stale)))
+    end
+    redis.call('zremrangebyscore', active, '-inf', '(' .. since)
+    redis.call('pexpire', active, math.ceil(window * 1000))
+    redis.call('pexpire', demand, math.ceil(window * 1000))
+  end
+
+  -- The demands of the other keys are taken as rates over the window, in
+  -- ascending order.
+  local rates, tested = {}, {}
+  for _, key in ipairs(keys) do
+    tested[key] = true
+  end
+  local recent = redis.call('zrangebyscore', active, since, '+inf', 'withscores')
+  for i = 1, #recent, 2 do
+    if not tested[recent[i]] then
+      rates[#rates + 1] = decayed(recent[i], tonumber(recent[i + 1])) / window
+    end
+  end
+  table.sort(rates)
+
+  for i = 1, #flows do
+    local remaining, count = flows[i], #rates + #keys
+    for _, rate in ipairs(rates) do
+      if rate * count > remaining then
+        break
+      end
+      remaining, count = remaining - rate, count - 1
+    end
+    local level = remaining / count
+    local share = level / flows[i]
+    flows[i], bursts[i] = level, bursts[i] * share
+    if integer then
+      flows[i], bursts[i] = math.max(1, math.floor(flows[i])), math.max(1, math.floor(bursts[i]))
+    end
+  end
+end
+
+-- Denials are recorded in the capped audit stream, if any, unless peeking.
+local function audit(key, cost, index)
+  if stream and not opts.peek then
+    redis.call('xadd', stream, 'maxlen', '~', opts.audit, '*',
+      'time', tostring(now), 'key', key, 'cost', tostring(cost), 'bucket', index)
+  end
+end
+
+-- Usage is counted in units for each key, if requested, unless peeking.
+local function meter(k, field, cost)
+  if meters[k] and not opts.peek then
+    redis.call('hincrbyfloat', meters[k], field, integer and cost / 1e6 or cost)
+    redis.call('expire', meters[k], opts.meter)
+  end
+end
+
+-- Denials are counted against every key, at the unweighted cost.
+local function meterDenied()
+  for k = 1, #meters do
+    meter(k, 'denied', cost)
+  end
+end
+
+-- Settling adjusts the cost already charged, which may be negative, without
+-- being denied by anything.
+local settle = opts.settle
+
+-- Transitions of a key between allowed and denied are published, if requested.
+local function notify(event, key)
+  if opts.notify then
+    redis.call('publish', opts.notify, event .. ':' .. key)
+  end
+end
+
+-- With the replica option, each key is a hash with a field for the state of
+-- each replica, of which only this replica's is read as the state of the key.
+local replica = opts.replica
+
+local function load(key)
+  if replica then
+    return redis.call('hget', key, replica)
+  end
+  return redis.pcall('get', key)
+end
+
+local function store(key, ttl, state)
+  if replica then
+    redis.call('hset', key, replica, state)
+    redis.call('expire', key, ttl)
+  else
+    redis.call('setex', key, ttl, state)
+  end
+end
+
+-- The levels of the other replicas, drained to the current time and summed.
+local function others(key)
+  local sum = {}
+  for n = 1, #flows do
+    sum[n] = 0
+  end
+  if replica then
+    local fields = redis.call('hgetall', key)
+    for i = 1, #fields, 2 do
+      local ok, last, _, levels = pcall(cmsgpack.unpack, fields[i + 1])
+      if fields[i] ~= replica and ok then
+        for n = 1, #flows do
+          sum[n] = sum[n] + math.max(0, (levels[n] or 0) - drained(last, flows[n]))
+        end
+      end
+    end
+  end
+  return sum
+end
+
+-- Evaluate every key before updating any of them, so that nothing is charged
+-- unless all of them allow it.
+local states, free, index, worst = {}, math.huge, 0, 1
+local drainAllow, drainDeny, fit = 0, 0, 0
+for k, key in ipairs(keys) do
+  local ok, last, deny, levels, strikes, penalty, born, denials, credit = pcall(cmsgpack.unpack, load(key))
+  if not ok then
+    last, deny, levels, born = now, 0, {}, now
+  end
+  strikes, penalty, born, denials, credit = strikes or 0, penalty or 0, born or 0, denials or 0, credit or 0
+
+  -- Banned keys are denied immediately, without evaluating any buckets.
+  if bans[k] and not settle then
+    local ban = redis.call('pttl', bans[k])
+    if ban > 0 then
+      audit(key, cost, 0)
+      meterDenied()
+      local wait = integer and ban or ban / 1000
+      return {0, out(integer and wait * 1000 or wait), 0, ms(wait), ms(wait)}
+    end
+  end
+
+  -- The stored times may be ahead of the current time, such as after a restore
+  -- from backup or a failover to a replica with a skewed clock, so they are
+  -- clamped rather than allowed to produce negative elapsed times.
+  born = math.min(born, now)
+  if duration then
+    penalty = math.min(penalty, now + duration)
+  end
+
+  -- The cost is multiplied by the weight of the key, if any.
+  local cost = cost
+  if weights then
+    cost = cost * (tonumber(redis.call('hget', weights, key)) or 1)
+    if integer then
+      cost = math.floor(cost + 0.5)
+    end
+  end
+
+  if penalty > now and not settle then
+    audit(key, cost, 0)
+    meterDenied()
+    local wait = penalty - now
+    return {0, out(integer and wait * 1000 or wait), 0, ms(wait), ms(wait)}
+  end
+
+  -- Keys which have used their quota are denied until the window ends.
+  local left = math.huge
+  if quotas[k] and not settle then
+    left = quota - (tonumber(redis.call('get', quotas[k])) or 0) - cost
+    if left < 0 then
+      audit(key, cost, 0)
+      meterDenied()
+      local wait = math.max(0, quotaEnd - now)
+      return {0, out(integer and wait * 1000 or wait), 0, ms(wait), ms(wait)}
+    end
+  end
+
+  local fill, ttl, other = {}, 0, others(key)
+  if banFlow then
+    denials = math.max(0, denials - drained(last, banFlow))
+  end
+
+  -- Capacity of the slowest bucket which drained away unused is partly kept.
+  if rollover then
+    local unused = drained(last, flows[1]) - (levels[1] or 0)
+    if unused > 0 then
+      credit = unused * rollover + credit
+      if integer then
+        credit = math.floor(credit)
+      end
+      credit = math.min(rolloverCap, credit)
+    end
+    ttl = math.ceil((bursts[1] + rolloverCap / rollover) / flows[1])
+  end
+
+  -- New keys start with a reduced burst which grows over the warm-up period.
+  local scale = 1
+  if ramp and now - born < ramp then
+    scale = start + (1 - start) * (now - born) / ramp
+    ttl = math.max(ttl, math.ceil((born + ramp - now) / tick))
+  end
+
+  for n = 1, #flows do
+    local burst = bursts[n] * scale
+    if integer then
+      burst = math.floor(burst)
+    end
+    if n == 1 then
+      burst = burst + credit
+    end
+    levels[n] = math.max(0, (levels[n] or 0) - drained(last, flows[n]))
+    fill[n] = levels[n] + cost
+    if settle then
+      fill[n] = math.max(0, fill[n])
+    end
+    local used = fill[n] + other[n]
+    if burst - used < free then
+      free, index, worst = burst - used, n, k
+    end
+    ttl = math.max(ttl, math.ceil(math.max(bursts[n], fill[n]) / flows[n]))
+    drainAllow = math.max(drainAllow, span(used, flows[n]))
+    drainDeny = math.max(drainDeny, span(levels[n] + other[n], flows[n]))
+    fit = math.max(fit, span(used - burst, flows[n]))
+  end
+
+  free = math.min(free, left)
+  states[k] = {key = key, cost = cost, deny = deny, levels = levels, fill = fill, strikes = strikes, born = born,
+    penalty = penalty, denials = denials, credit = credit, ttl = ttl}
+end
+
+-- Peeking reports what the result would be without updating any state.
+if opts.peek then
+  if free >= 0 then
+    return {1, out(free), index, ms(drainDeny), ms(0)}
+  end
+  return {0, out(states[worst].deny + states[worst].cost), index, ms(drainDeny), ms(fit)}
+end
+
+if free >= 0 or settle then
+  for _, s in ipairs(states) do
+    -- Any of the cost beyond the burst of the slowest bucket spends the credit.
+    if rollover and s.fill[1] > bursts[1] then
+      local spent = math.min(s.credit, s.fill[1] - bursts[1])
+      s.credit, s.fill[1] = s.credit - spent, s.fill[1] - spent
+    end
+    -- Settling leaves any denials and penalty as they were.
+    if settle then
+      store(s.key, s.ttl, cmsgpack.pack(now, s.deny, s.fill, s.strikes, s.penalty, s.born, s.denials, s.credit))
+    else
+      store(s.key, s.ttl, cmsgpack.pack(now, 0, s.fill, 0, 0, s.born, s.denials, s.credit))
+      if s.deny > 0 then
+        notify('allowed', s.key)
+      end
+    end
+  end
+  for k, s in ipairs(states) do
+    meter(k, 'allowed', s.cost)
+  end
+  for k, q in ipairs(quotas) do
+    redis.call('incrbyfloat', q, states[k].cost)
+    redis.call('pexpireat', q, math.ceil(tonumber(opts.quota_end) * 1000))
+  end
+  if dedup then
+    redis.call('set', dedup, cmsgpack.pack(free, index), 'px', math.ceil(tonumber(opts.dedup) * 1000))
+  end
+  return {1, out(free), index, ms(drainAllow), ms(0)}
+end
+
+-- Only the most restrictive key is charged with the denial, at its own weight.
+meterDenied()
+local s = states[worst]
+if s.deny == 0 then
+  notify('denied', s.key)
+end
+s.deny, s.strikes, s.denials = s.deny + s.cost, s.strikes + 1, s.denials + s.cost
+
+-- Keys which sustain denials beyond the ban rate are banned, and start afresh.
+if banLimit and s.denials > banLimit then
+  redis.call('set', bans[worst], 1, 'px', math.ceil(banTTL * 1000))
+  store(s.key, s.ttl, cmsgpack.pack(now, s.deny, s.levels, 0, 0, s.born, 0, s.credit))
+  audit(s.key, s.cost, 0)
+  local wait = integer and math.ceil(banTTL * 1000) or banTTL
+  return {0, out(integer and wait * 1000 or wait), 0, ms(wait), ms(wait)}
+end
+
+if threshold and s.strikes >= threshold then
+  s.strikes, s.ttl = 0, math.max(s.ttl, math.ceil(duration / tick))
+  store(s.key, s.ttl, cmsgpack.pack(now, s.deny, s.levels, s.strikes, now + duration, s.born, s.denials, s.credit))
+  audit(s.key, s.cost, 0)
+  return {0, out(integer and duration * 1000 or duration), 0, ms(duration), ms(duration)}
+end
+
+store(s.key, s.ttl, cmsgpack.pack(now, s.deny, s.levels, s.strikes, 0, s.born, s.denials, s.credit))
+audit(s.key, s.cost, index)
+return {0, out(s.deny), index, ms(drainDeny), ms(fit)}
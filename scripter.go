@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "context"
+
+type (
+	// Cmd represents the result of a command issued by a Scripter.
+	Cmd interface {
+		Result() (any, error)
+	}
+
+	// Scripter represents a go-redis style client, whose scripting commands
+	// take variadic arguments and return a command result, such as the
+	// Scripter interface of go-redis itself (using *redis.Cmd for C).
+	Scripter[C Cmd] interface {
+		Eval(ctx context.Context, script string, keys []string, args ...any) C
+		EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) C
+	}
+
+	scripter[C Cmd] struct{ Scripter[C] }
+)
+
+// NewScripter creates a new rate-limiter instance using a go-redis style
+// client directly, without the need to implement the Eval interface. Any of
+// the optional interfaces of this package (such as Scan) which the client
+// implements are used as if it had been passed to New.
+func NewScripter[C Cmd](redis Scripter[C], bucket Bucket, configs ...Config) (*Limiter, error) {
+	if redis == nil {
+		return New(nil, bucket, configs...)
+	}
+	return New(scripter[C]{redis}, bucket, configs...)
+}
+
+// The wrapped client, so that any optional interfaces which it implements (such
+// as ScriptLoad, EvalRO, Pipeline, Scan or Subscribe) are still used.
+func (s scripter[C]) unwrap() any {
+	return s.Scripter
+}
+
+func (s scripter[C]) Eval(ctx context.Context, script string, keys []string, args []any) (any, error) {
+	return s.Scripter.Eval(ctx, script, keys, args...).Result()
+}
+
+func (s scripter[C]) EvalSha(ctx context.Context, sha string, keys []string, args []any) (any, error) {
+	return s.Scripter.EvalSha(ctx, sha, keys, args...).Result()
+}
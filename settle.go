@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"math"
+)
+
+// Settle adjusts the cost already charged to the given key by the given delta,
+// for actions admitted at an estimated cost whose actual cost is only known
+// once they complete, such as the size of a response or the time spent
+// computing it. It is called with the actual cost minus the estimate: positive
+// deltas are charged even beyond the burst, so that later actions wait for
+// them to drain, while negative deltas are refunded without taking any bucket
+// below empty. Any quota and metered usage are adjusted likewise. The
+// adjustment is applied atomically by the script, and is never denied, nor
+// counted as a denial; any penalty box is left as it was. The result reports
+// the remaining capacity, which is negative while the key is overdrawn.
+// Capacity already leased locally is not adjusted.
+func (l *Limiter) Settle(ctx context.Context, key string, delta float64) (Result, error) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return Result{}, &CostError{Cost: delta}
+	}
+	if l.bypassed(key) {
+		return unlimited(), nil
+	}
+	k, s := l.shard(key)
+	return l.test(ctx, key, s, []string{k}, delta, false, "settle", 1)
+}
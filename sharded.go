@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// ShardedLimiter distributes keys across several Redis instances using
+	// consistent hashing, so that load can scale beyond a single primary.
+	// Shards which fail are skipped for a cooldown period, during which their
+	// keys are served by the next shard on the ring.
+	ShardedLimiter struct {
+		shards   []*shard
+		ring     []point
+		cooldown time.Duration
+	}
+
+	shard struct {
+		limiter *Limiter
+		down    int64
+	}
+
+	point struct {
+		hash  uint64
+		shard int
+	}
+)
+
+// The number of points each shard occupies on the ring, to even out the
+// distribution of keys between shards.
+const replicas = 128
+
+// NewSharded creates a rate-limiter which distributes keys across the given
+// Redis clients, each configured identically with the given parameters.
+func NewSharded(clients []Eval, bucket Bucket, configs ...Config) (*ShardedLimiter, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("limiter: must have at least one redis client")
+	}
+
+	s := &ShardedLimiter{cooldown: 10 * time.Second}
+	for i, client := range clients {
+		l, err := New(client, bucket, configs...)
+		if err != nil {
+			return nil, err
+		}
+		s.shards = append(s.shards, &shard{limiter: l})
+		for r := 0; r < replicas; r++ {
+			s.ring = append(s.ring, point{hash(strconv.Itoa(i) + ":" + strconv.Itoa(r)), i})
+		}
+	}
+	sort.Slice(s.ring, func(i int, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return s, nil
+}
+
+// Test whether the given action should be allowed according to the rate limits.
+func (s *ShardedLimiter) Test(ctx context.Context, key string, cost float64) (Result, error) {
+	return s.do(ctx, key, func(l *Limiter) (Result, error) { return l.Test(ctx, key, cost) })
+}
+
+// Peek reports whether the given action would be allowed, as with Limiter.Peek.
+func (s *ShardedLimiter) Peek(ctx context.Context, key string, cost float64) (Result, error) {
+	return s.do(ctx, key, func(l *Limiter) (Result, error) { return l.Peek(ctx, key, cost) })
+}
+
+// Healthy reports whether each shard, in the order given, is currently in use.
+func (s *ShardedLimiter) Healthy() []bool {
+	now := time.Now().UnixNano()
+	healthy := make([]bool, len(s.shards))
+	for i, sh := range s.shards {
+		healthy[i] = atomic.LoadInt64(&sh.down) <= now
+	}
+	return healthy
+}
+
+func (s *ShardedLimiter) do(ctx context.Context, key string, test func(*Limiter) (Result, error)) (Result, error) {
+	sh := s.shard(key)
+	res, err := test(sh.limiter)
+	// Only failures of the shard itself, rather than of the caller (such as an
+	// invalid cost), are counted against its health.
+	var cost *CostError
+	if err != nil && ctx.Err() == nil && !errors.As(err, &cost) {
+		atomic.StoreInt64(&sh.down, time.Now().Add(s.cooldown).UnixNano())
+	}
+	return res, err
+}
+
+// Find the first healthy shard at or after the hash of the key on the ring,
+// falling back to the shard which owns the key if none are healthy.
+func (s *ShardedLimiter) shard(key string) *shard {
+	h := hash(key)
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	now := time.Now().UnixNano()
+	for i := 0; i < len(s.ring); i++ {
+		sh := s.shards[s.ring[(start+i)%len(s.ring)].shard]
+		if atomic.LoadInt64(&sh.down) <= now {
+			return sh
+		}
+	}
+	return s.shards[s.ring[start%len(s.ring)].shard]
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
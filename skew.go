@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const skewTime = `return redis.call('time')`
+
+// Skew measures the difference between the local clock (or the clock supplied
+// by WithClock) and the clock of Redis, which is positive when the local clock
+// is ahead. The round trip is accounted for by comparing against its midpoint.
+func (l *Limiter) Skew(ctx context.Context) (time.Duration, error) {
+	now := time.Now
+	if l.clock != nil {
+		now = l.clock.Now
+	}
+
+	start := now()
+	raw, err := l.redis.Eval(ctx, skewTime, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	end := now()
+
+	res, ok := raw.([]any)
+	if !ok || len(res) != 2 {
+		return 0, errInvalidReply
+	}
+	var parts [2]int64
+	for i := range parts {
+		switch v := res[i].(type) {
+		case int64:
+			parts[i] = v
+		case string:
+			if parts[i], err = strconv.ParseInt(v, 10, 64); err != nil {
+				return 0, errInvalidReply
+			}
+		default:
+			return 0, errInvalidReply
+		}
+	}
+
+	server := time.Unix(parts[0], parts[1]*int64(time.Microsecond))
+	return start.Add(end.Sub(start) / 2).Sub(server), nil
+}
+
+// WatchSkew measures the clock skew, as with Skew, at the given interval until
+// the context is done, calling onSkew whenever it exceeds the threshold in
+// either direction. Large skew distorts retry times computed locally from the
+// decisions made by Redis. If a logger has been configured, excessive skew is
+// logged at the warning level, and failed measurements at the error level.
+func (l *Limiter) WatchSkew(ctx context.Context, interval time.Duration, threshold time.Duration, onSkew func(skew time.Duration)) error {
+	if interval <= 0 {
+		return errors.New("limiter: skew interval must be positive")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			l.checkSkew(ctx, threshold, onSkew)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *Limiter) checkSkew(ctx context.Context, threshold time.Duration, onSkew func(skew time.Duration)) {
+	skew, err := l.Skew(ctx)
+	switch {
+	case err != nil:
+		if l.logger != nil && ctx.Err() == nil {
+			l.logger.LogAttrs(ctx, slog.LevelError, "clock skew measurement failed", slog.Any("error", err))
+		}
+	case skew > threshold || skew < -threshold:
+		if onSkew != nil {
+			onSkew(skew)
+		}
+		if l.logger != nil {
+			l.logger.LogAttrs(ctx, slog.LevelWarn, "clock skew exceeds threshold",
+				slog.Duration("skew", skew), slog.Duration("threshold", threshold))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+// WithSpikeArrest smooths microbursts which the other buckets would admit, such
+// as a per-minute bucket admitting its whole burst at once, by also limiting
+// bursts to the given cost, which becomes available again over the given short
+// window (for example, 10 per 100ms). It is evaluated in the same call as the
+// other buckets, as an additional bucket; a denial by it is reported with its
+// bucket number, as with any other.
+func WithSpikeArrest(cost float64, window time.Duration) Config {
+	return func(c *config) {
+		if window <= 0 {
+			if c.err == nil {
+				c.err = errors.New("limiter: spike arrest window must be positive")
+			}
+			return
+		}
+		WithAdditionalBucket(Rate{Flow: cost / window.Seconds(), Burst: cost})(c)
+	}
+}
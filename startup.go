@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Exercises the server features that the script depends on.
+const check = `redis.replicate_commands()
+local time = redis.call('time')
+return cmsgpack.unpack(cmsgpack.pack(#time))`
+
+// WithStartupCheck verifies that Redis is reachable and supports everything the
+// script requires when the limiter is created, within the given timeout, so
+// that misconfiguration is reported by New rather than on first use.
+func WithStartupCheck(timeout time.Duration) Config {
+	return func(c *config) { c.startup = timeout }
+}
+
+func (l *Limiter) startupCheck(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := l.redis.Eval(ctx, check, nil, nil)
+	if err != nil {
+		return fmt.Errorf("limiter: startup check could not evaluate scripts using TIME: %w", err)
+	}
+	if res != int64(2) {
+		return fmt.Errorf("limiter: startup check got unexpected reply %v from scripts", res)
+	}
+
+	if _, ok := as[ScriptLoad](l.redis); ok {
+		if err := l.Preload(ctx); err != nil {
+			return fmt.Errorf("limiter: startup check could not load the script: %w", err)
+		}
+	}
+
+	if _, err := l.Peek(ctx, "startup-check", 1); err != nil {
+		return fmt.Errorf("limiter: startup check could not execute the script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "sync/atomic"
+
+type (
+	// Stats provides counts of the behavior of a limiter since it was created.
+	Stats struct {
+		// Tests is the number of calls to Test.
+		Tests uint64
+
+		// Allows is the number of tests which were allowed.
+		Allows uint64
+
+		// Denies is the number of tests which were denied.
+		Denies uint64
+
+		// Errors is the number of tests which failed.
+		Errors uint64
+
+		// Fallbacks is the number of calls which sent the full script to Redis,
+		// as it was not already loaded.
+		Fallbacks uint64
+	}
+
+	stats struct {
+		tests, allows, denies, errors, fallbacks atomic.Uint64
+	}
+)
+
+// Stats returns a snapshot of the counts of the behavior of the limiter.
+func (l *Limiter) Stats() Stats {
+	return Stats{
+		Tests:     l.stats.tests.Load(),
+		Allows:    l.stats.allows.Load(),
+		Denies:    l.stats.denies.Load(),
+		Errors:    l.stats.errors.Load(),
+		Fallbacks: l.stats.fallbacks.Load(),
+	}
+}
+
+func (s *stats) count(res Result, err error) {
+	s.tests.Add(1)
+	switch {
+	case err != nil:
+		s.errors.Add(1)
+	case res.Allow:
+		s.allows.Add(1)
+	default:
+		s.denies.Add(1)
+	}
+}
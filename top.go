@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type (
+	// Records the cost denied to each key in a sorted set per window, in the
+	// background, so that the most denied keys may be retrieved.
+	top struct {
+		window  time.Duration
+		windows int
+		slots   chan struct{}
+	}
+
+	// Offender provides the total cost denied to a key.
+	Offender struct {
+		Key    string
+		Denied float64
+	}
+)
+
+const topRecord = `redis.call('zincrby', KEYS[1], ARGV[1], ARGV[2])
+redis.call('expire', KEYS[1], ARGV[3])
+return 1`
+
+// The union is stored in a temporary key sharing the slot of the windows.
+const topQuery = `local tmp = table.remove(KEYS)
+redis.call('zunionstore', tmp, #KEYS, unpack(KEYS))
+local top = redis.call('zrevrange', tmp, 0, tonumber(ARGV[1]) - 1, 'withscores')
+redis.call('del', tmp)
+return top`
+
+// WithTopDenied records the cost denied to each key in Redis, in sorted sets
+// covering consecutive windows of the given duration, so that the most denied
+// keys over the given number of most recent windows may be retrieved using
+// TopDenied. Denials are recorded in the background on a best-effort basis,
+// and only for Test and tests in batches.
+func WithTopDenied(window time.Duration, windows int) Config {
+	return func(c *config) { c.top = &top{window: window, windows: windows} }
+}
+
+func (t *top) validate() error {
+	if t != nil && (t.window <= 0 || t.windows <= 0) {
+		return errors.New("limiter: top denied window and count must be positive")
+	}
+	return nil
+}
+
+// The windows all share a hash tag, so that they may be combined in a cluster.
+func (t *top) keys(prefix string, now time.Time) []string {
+	n := now.UnixNano() / int64(t.window)
+	keys := make([]string, t.windows)
+	for i := range keys {
+		keys[i] = "{" + prefix + "top-denied}:" + strconv.FormatInt(n-int64(i), 10)
+	}
+	return keys
+}
+
+func (l *Limiter) recordDenied(key string, cost float64) {
+	t := l.top
+	select {
+	case t.slots <- struct{}{}:
+		go func() {
+			defer func() { <-t.slots }()
+			ctx, cancel := l.deadline(context.Background())
+			defer cancel()
+			ttl := math.Ceil((t.window * time.Duration(t.windows)).Seconds())
+			_, _ = l.redis.Eval(ctx, topRecord, t.keys(l.prefix, l.now())[:1], []any{cost, l.id(key), ttl})
+		}()
+	default:
+	}
+}
+
+// TopDenied returns up to n keys which have been denied the most cost over the
+// recorded windows, in descending order. This requires WithTopDenied.
+func (l *Limiter) TopDenied(ctx context.Context, n int) ([]Offender, error) {
+	if l.top == nil {
+		return nil, errors.New("limiter: top denied keys are not recorded")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	keys := l.top.keys(l.prefix, l.now())
+	keys = append(keys, "{"+l.prefix+"top-denied}:union")
+	raw, err := l.redis.Eval(ctx, topQuery, keys, []any{n})
+	if err != nil {
+		return nil, err
+	}
+
+	res, ok := raw.([]any)
+	if !ok || len(res)%2 != 0 {
+		return nil, errInvalidReply
+	}
+	top := make([]Offender, 0, len(res)/2)
+	for i := 0; i < len(res); i += 2 {
+		key, ok := res[i].(string)
+		if !ok {
+			return nil, errInvalidReply
+		}
+		denied, ok := parseFloat(res[i+1])
+		if !ok {
+			return nil, errInvalidReply
+		}
+		top = append(top, Offender{Key: key, Denied: denied})
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Denied > top[j].Denied })
+	return top, nil
+}
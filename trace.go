@@ -0,0 +1,19 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "context"
+
+// Tracer traces each call to Test, for export to a distributed tracing system.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start is called at the beginning of each test, and returns the context
+	// under which it proceeds and a function to call with its outcome.
+	Start(ctx context.Context, key string, cost float64) (context.Context, func(Result, error))
+}
+
+// WithTracer traces each call to Test using the given tracer.
+func WithTracer(tracer Tracer) Config {
+	return func(c *config) { c.tracer = tracer }
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import "net/http"
+
+// Transport is an http.RoundTripper which throttles outgoing requests, waiting
+// until each is allowed by the limiter before sending it. As the limits are
+// held in Redis, they are shared by every instance sending requests.
+type Transport struct {
+	// Limiter is the limiter which requests must be allowed by.
+	Limiter *Limiter
+
+	// Base is the transport used to send requests once allowed. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Key derives the key for each request. If nil, the host is used.
+	Key func(*http.Request) string
+
+	// Cost derives the cost of each request. If nil, each request costs 1.
+	Cost func(*http.Request) float64
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, cost := req.URL.Host, 1.0
+	if t.Key != nil {
+		key = t.Key(req)
+	}
+	if t.Cost != nil {
+		cost = t.Cost(req)
+	}
+	if _, err := t.Limiter.Wait(req.Context(), key, cost); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+)
+
+// Validate checks the given buckets and configuration without constructing a
+// limiter, so that definitions of limits may be verified ahead of time. It
+// reports everything that New would reject, other than the capabilities of
+// the client, and additionally rejects superfluous buckets (those which are
+// strictly larger than another, and so would never apply).
+func Validate(bucket Bucket, configs ...Config) error {
+	c := newConfig(bucket, configs)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if _, err := c.settings(); err != nil {
+		return err
+	}
+	if _, dropped := effectiveRates(c.rates); len(dropped) > 0 {
+		return errors.New("limiter: bucket is superfluous: " + formatRate(dropped[0]))
+	}
+	return nil
+}
+
+func (c *config) validate() error {
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.retry.validate(); err != nil {
+		return err
+	}
+	if err := c.lease.validate(); err != nil {
+		return err
+	}
+	if err := c.hot.validate(); err != nil {
+		return err
+	}
+	if err := c.region.validate(); err != nil {
+		return err
+	}
+	if err := c.top.validate(); err != nil {
+		return err
+	}
+	if err := c.validateCluster(); err != nil {
+		return err
+	}
+	if c.jitter < 0 || c.jitter > 1 {
+		return errors.New("limiter: jitter must be between 0 and 1")
+	}
+	if c.maxWait < 0 {
+		return errors.New("limiter: maximum wait must be positive")
+	}
+	if c.timeout < 0 {
+		return errors.New("limiter: timeout must be positive")
+	}
+	if c.instances < 0 {
+		return errors.New("limiter: fallback instances must be positive")
+	}
+	return nil
+}
+
+func formatRate(r Rate) string {
+	return "flow " + strconv.FormatFloat(r.Flow, 'g', -1, 64) +
+		", burst " + strconv.FormatFloat(r.Burst, 'g', -1, 64)
+}
+
+// Report any buckets of the configuration which are ignored as superfluous,
+// including those of the schedule, through the hooks and logger.
+func (l *Limiter) warn(c *config) {
+	_, dropped := effectiveRates(c.rates)
+	for _, w := range c.schedule.windows {
+		_, d := effectiveRates(w.rates)
+		dropped = append(dropped, d...)
+	}
+	l.superfluous(dropped)
+}
+
+// Report the given buckets as ignored, through the hooks and logger.
+func (l *Limiter) superfluous(dropped []Rate) {
+	for _, r := range dropped {
+		if l.hooks.OnSuperfluous != nil {
+			l.hooks.OnSuperfluous(r)
+		}
+		if l.logger != nil {
+			l.logger.LogAttrs(context.Background(), slog.LevelWarn, "superfluous bucket ignored",
+				slog.Float64("flow", r.Flow),
+				slog.Float64("burst", r.Burst))
+		}
+	}
+}
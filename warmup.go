@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"errors"
+	"time"
+)
+
+type warmup struct {
+	start float64
+	ramp  time.Duration
+}
+
+// WithWarmup starts new keys with only the given fraction of their burst
+// capacity, growing linearly to the full burst over the ramp duration. This
+// prevents freshly created keys from immediately bursting at full capacity.
+func WithWarmup(start float64, ramp time.Duration) Config {
+	return func(c *config) { c.warmup = warmup{start, ramp} }
+}
+
+func (w warmup) args() ([]any, error) {
+	if w.start == 0 && w.ramp == 0 {
+		return nil, nil
+	}
+	if w.start <= 0 || w.start > 1 || w.ramp <= 0 {
+		return nil, errors.New("limiter: warm-up must start within (0, 1] over a positive ramp")
+	}
+	return []any{"warmup", w.start, "warmup_ttl", w.ramp.Seconds()}, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// Subscribe represents a Redis client supporting SUBSCRIBE, delivering the
+// payload of each message published on the channel until the context is done.
+type Subscribe interface {
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+const watchLoad = `return redis.call('hget', KEYS[1], ARGV[1])`
+
+// WatchBuckets replaces the buckets of the limiter with those defined in the
+// given field of a Redis hash, and again whenever a message is published on
+// the given channel (which may be a keyspace notification channel for the
+// hash), until the context is done. The field holds a comma-separated list of
+// buckets, in the format described by ConfigFromEnv, so that many limiters
+// may be defined in a single hash. The loaded buckets apply at all times,
+// replacing those of any schedule windows as well as the default buckets, and
+// superfluous buckets are reported as with New. This requires a client
+// supporting Subscribe. Invalid definitions are ignored in favor of the current
+// buckets, and are logged if a logger has been configured.
+func (l *Limiter) WatchBuckets(ctx context.Context, hash string, field string, channel string) error {
+	sub, ok := as[Subscribe](l.redis)
+	if !ok {
+		return errors.New("limiter: watching requires a client supporting SUBSCRIBE")
+	}
+
+	// Subscribe before loading, so that no change in between is missed.
+	messages, err := sub.Subscribe(ctx, channel)
+	if err != nil {
+		return err
+	}
+	if err := l.loadBuckets(ctx, hash, field); err != nil {
+		return err
+	}
+
+	go func() {
+		for range messages {
+			if err := l.loadBuckets(ctx, hash, field); err != nil && l.logger != nil && ctx.Err() == nil {
+				l.logger.LogAttrs(ctx, slog.LevelError, "rate limit reload failed",
+					slog.String("hash", hash), slog.String("field", field), slog.Any("error", err))
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *Limiter) loadBuckets(ctx context.Context, hash string, field string) error {
+	raw, err := l.redis.Eval(ctx, watchLoad, []string{hash}, []any{field})
+	if err != nil {
+		return err
+	}
+	def, ok := raw.(string)
+	if !ok {
+		return errors.New("limiter: no buckets defined in " + hash + " " + field)
+	}
+
+	var rates []Rate
+	for _, b := range strings.Split(def, ",") {
+		bucket, err := parseBucket(strings.TrimSpace(b))
+		if err != nil {
+			return errors.New("limiter: invalid bucket in " + hash + " " + field + ": " + b)
+		}
+		flow, burst := bucket.Rate()
+		rates = append(rates, Rate{flow, burst})
+	}
+	if err := l.setRates(rates); err != nil {
+		return err
+	}
+	_, dropped := effectiveRates(rates)
+	l.superfluous(dropped)
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+type weights string
+
+const weightsSet = `
+if tonumber(ARGV[2]) == 1 then
+  return redis.call('hdel', KEYS[1], ARGV[1])
+end
+return redis.call('hset', KEYS[1], ARGV[1], ARGV[2])`
+
+// WithWeights multiplies the cost of each call by a weight for its key, read
+// by the script from the given Redis hash, so that trusted callers may have
+// their costs discounted and abusive ones surcharged. Keys without a weight
+// have a weight of 1. Weights are set using SetWeight. The hash is shared by
+// every key, so this cannot be used with WithCluster.
+func WithWeights(hash string) Config {
+	return func(c *config) { c.weights = weights(hash) }
+}
+
+func (w weights) args() ([]any, error) {
+	if w == "" {
+		return nil, nil
+	}
+	return []any{"weights", 1}, nil
+}
+
+// SetWeight sets the weight by which the cost of each call for the given key
+// is multiplied. This requires WithWeights.
+func (l *Limiter) SetWeight(ctx context.Context, key string, weight float64) error {
+	if l.weights == "" {
+		return errors.New("limiter: weights are not enabled")
+	}
+	if weight < 0 {
+		return errors.New("limiter: weight must not be negative")
+	}
+	_, err := l.redis.Eval(ctx, weightsSet, []string{string(l.weights)}, []any{l.key(key), weight})
+	return err
+}